@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"service-b/platform"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	// defaultAPIKeyQuota applies to API_KEYS entries given without ":N".
+	defaultAPIKeyQuota = 60
+
+	apiKeyWindow = time.Minute
+)
+
+// apiKeyQuotas admits requests carrying a known X-API-Key, each key allowed
+// at most its quota of requests in any rolling minute.
+type apiKeyQuotas struct {
+	mu     sync.Mutex
+	limits map[string]int
+	clock  clock
+	hits   map[string][]time.Time
+}
+
+func newAPIKeyQuotas(limits map[string]int) *apiKeyQuotas {
+	return &apiKeyQuotas{limits: limits, clock: realClock{}, hits: make(map[string][]time.Time)}
+}
+
+// parseAPIKeys parses API_KEYS, a comma-separated list of key[:perMinute]
+// entries such as "abc:120,def".
+func parseAPIKeys(s string) (map[string]int, error) {
+	keys := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, quota, hasQuota := strings.Cut(entry, ":")
+		// An empty key would match requests that send none.
+		if key = strings.TrimSpace(key); key == "" {
+			return nil, fmt.Errorf("API key missing in %q", entry)
+		}
+		n := defaultAPIKeyQuota
+		if hasQuota {
+			var err error
+			if n, err = strconv.Atoi(strings.TrimSpace(quota)); err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid quota %q for an API key", quota)
+			}
+		}
+		keys[key] = n
+	}
+	return keys, nil
+}
+
+// allow records a request for key. known is false for unknown keys; when
+// the key is over quota, ok is false and wait is how long until the oldest
+// request in the window expires.
+func (q *apiKeyQuotas) allow(key string) (known, ok bool, wait time.Duration) {
+	limit, known := q.limits[key]
+	if !known {
+		return false, false, 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	hits := q.hits[key]
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= apiKeyWindow {
+		i++
+	}
+	hits = hits[i:]
+	if len(hits) >= limit {
+		q.hits[key] = hits
+		return true, false, apiKeyWindow - now.Sub(hits[0])
+	}
+	q.hits[key] = append(hits, now)
+	return true, true, 0
+}
+
+// middleware rejects requests without a known API key with 401 and keys over
+// their quota with 429 and Retry-After.
+func (q *apiKeyQuotas) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		known, ok, wait := q.allow(r.Header.Get(apiKeyHeader))
+		if !known {
+			platform.WriteError(w, r, http.StatusUnauthorized, "invalid_api_key", "invalid or missing API key")
+			return
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+			platform.WriteError(w, r, http.StatusTooManyRequests, "quota_exceeded", "API key quota exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unaryInterceptor applies the quotas to gRPC calls, reading the key from
+// the x-api-key metadata. Unknown keys get Unauthenticated and keys over
+// their quota ResourceExhausted, with a retry-after header in seconds.
+func (q *apiKeyQuotas) unaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(apiKeyHeader); len(v) > 0 {
+			key = v[0]
+		}
+	}
+	known, ok, wait := q.allow(key)
+	if !known {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing API key")
+	}
+	if !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1))))
+		return nil, status.Error(codes.ResourceExhausted, "API key quota exceeded")
+	}
+	return handler(ctx, req)
+}
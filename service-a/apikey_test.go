@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIKeyUnaryInterceptor(t *testing.T) {
+	q := newAPIKeyQuotas(map[string]int{"good": 1})
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		want codes.Code
+	}{
+		{"missing key", nil, codes.Unauthenticated},
+		{"unknown key", metadata.Pairs("x-api-key", "bad"), codes.Unauthenticated},
+		{"known key", metadata.Pairs("x-api-key", "good"), codes.OK},
+		{"over quota", metadata.Pairs("x-api-key", "good"), codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+			resp, err := q.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("code = %v, want %v", got, tt.want)
+			}
+			if tt.want == codes.OK && resp != "ok" {
+				t.Errorf("handler not called, resp = %v", resp)
+			}
+		})
+	}
+}
+
+func TestAPIKeyQuotaWindow(t *testing.T) {
+	clk := newFakeClock()
+	q := newAPIKeyQuotas(map[string]int{"k": 2})
+	q.clock = clk
+
+	for i := 0; i < 2; i++ {
+		if _, ok, _ := q.allow("k"); !ok {
+			t.Fatalf("request %d rejected within quota", i)
+		}
+		clk.Advance(10 * time.Second)
+	}
+	_, ok, wait := q.allow("k")
+	if ok || wait != 40*time.Second {
+		t.Fatalf("third request = ok %v, wait %v; want rejected for 40s", ok, wait)
+	}
+	clk.Advance(wait)
+	if _, ok, _ := q.allow("k"); !ok {
+		t.Fatal("request rejected after the oldest one left the window")
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"", map[string]int{}, false},
+		{"abc", map[string]int{"abc": defaultAPIKeyQuota}, false},
+		{"abc:120, def", map[string]int{"abc": 120, "def": defaultAPIKeyQuota}, false},
+		{"abc:1,,", map[string]int{"abc": 1}, false},
+		{"abc : 5", map[string]int{"abc": 5}, false},
+		{":10", nil, true},
+		{"abc, :10", nil, true},
+		{" :", nil, true},
+		{"abc:0", nil, true},
+		{"abc:-5", nil, true},
+		{"abc:many", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseAPIKeys(tt.in)
+		if (err != nil) != tt.wantErr || !maps.Equal(got, tt.want) {
+			t.Errorf("parseAPIKeys(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	clk := newFakeClock()
+	q := newAPIKeyQuotas(map[string]int{"gold": 2, "silver": 1})
+	q.clock = clk
+	h := q.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name           string
+		key            string
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+	}{
+		{"valid key", "gold", http.StatusOK, "", ""},
+		{"valid key under quota", "gold", http.StatusOK, "", ""},
+		{"valid key over quota", "gold", http.StatusTooManyRequests, "quota_exceeded", "60"},
+		{"other key keeps its quota", "silver", http.StatusOK, "", ""},
+		{"unknown key", "bronze", http.StatusUnauthorized, "invalid_api_key", ""},
+		{"missing key", "", http.StatusUnauthorized, "invalid_api_key", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+		if tt.key != "" {
+			req.Header.Set(apiKeyHeader, tt.key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+			continue
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.name, got, tt.wantRetryAfter)
+		}
+		if tt.wantCode != "" {
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("%s: code = %q, want %q", tt.name, e.Error.Code, tt.wantCode)
+			}
+		}
+	}
+
+	clk.Advance(apiKeyWindow)
+	req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+	req.Header.Set(apiKeyHeader, "gold")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("after a minute: status = %d, want 200", rec.Code)
+	}
+}
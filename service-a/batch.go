@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"service-b/platform"
+)
+
+var (
+	// batchConcurrency bounds concurrent service-b calls per batch request;
+	// set from BATCH_CONCURRENCY.
+	batchConcurrency = 8
+
+	// batchMaxSize caps the number of CEPs in one batch; set from BATCH_MAX_SIZE.
+	batchMaxSize = 100
+)
+
+type batchReq struct {
+	CEPs []string `json:"ceps"`
+}
+
+// batchResult carries either the service-b weather payload or an error for
+// one CEP of a batch.
+type batchResult struct {
+	CEP     string          `json:"cep"`
+	Weather json.RawMessage `json:"weather,omitempty"`
+	Error   *errorDetail    `json:"error,omitempty"`
+}
+
+// handleCEPBatch resolves many CEPs in one request. Results keep the order of
+// the input and failures are reported per CEP rather than failing the batch.
+func handleCEPBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		platform.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	var payload batchReq
+	dec := newJSONDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err := dec.Decode(&payload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			platform.WriteError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+			return
+		}
+		platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	if len(payload.CEPs) > batchMaxSize {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "batch_too_large",
+			fmt.Sprintf("at most %d ceps per batch", batchMaxSize))
+		return
+	}
+
+	results := lookupBatch(r.Context(), payload.CEPs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// lookupBatch resolves ceps with at most batchConcurrency calls in flight.
+// CEPs not started before ctx is done are reported as canceled.
+func lookupBatch(ctx context.Context, ceps []string) []batchResult {
+	results := make([]batchResult, len(ceps))
+	sem := make(chan struct{}, max(batchConcurrency, 1))
+	var wg sync.WaitGroup
+
+	for i, raw := range ceps {
+		select {
+		case <-ctx.Done():
+			results[i] = batchResult{CEP: raw, Error: &errorDetail{Code: "canceled", Message: "request canceled"}}
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int, raw string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = lookupOne(ctx, raw)
+		}(i, raw)
+	}
+	wg.Wait()
+	return results
+}
+
+func lookupOne(ctx context.Context, raw string) batchResult {
+	cep, ok := validateCEP(raw)
+	if !ok {
+		return batchResult{CEP: raw, Error: &errorDetail{
+			Code:    "invalid_zipcode",
+			Message: "invalid zipcode",
+			Details: []fieldError{{Field: "cep", Reason: cepProblem(cep)}},
+		}}
+	}
+	if !cepAllowed(cep) {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "zipcode_not_allowed", Message: "zipcode outside the served area"}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	resp, err := forwardToServiceB(ctx, cep, "application/json", "")
+	if errors.Is(err, errCircuitOpen) {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "service_unavailable", Message: "service-b unavailable"}}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "gateway_timeout", Message: "gateway timeout"}}
+	}
+	if err != nil {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "bad_gateway", Message: "bad gateway"}}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if errors.Is(err, platform.ErrUpstreamTooLarge) {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "upstream_too_large", Message: "upstream response too large"}}
+	}
+	if err != nil {
+		return batchResult{CEP: cep, Error: &errorDetail{Code: "bad_gateway", Message: "bad gateway"}}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return batchResult{CEP: cep, Weather: body}
+	}
+	var e errorResp
+	decodeErr := json.Unmarshal(body, &e)
+	if se := stableError(resp.StatusCode, e.Error.Code); se != nil {
+		return batchResult{CEP: cep, Error: se}
+	}
+	if decodeErr != nil || e.Error.Code == "" {
+		return batchResult{CEP: cep, Error: &errorDetail{
+			Code:    "upstream_error",
+			Message: fmt.Sprintf("service-b status %d", resp.StatusCode),
+		}}
+	}
+	return batchResult{CEP: cep, Error: &e.Error}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandleCEPBatchBodyTooLarge(t *testing.T) {
+	prev := maxBodyBytes
+	maxBodyBytes = 64
+	t.Cleanup(func() { maxBodyBytes = prev })
+
+	body := `{"ceps":["` + strings.Repeat("01001000", 20) + `"]}`
+	req := httptest.NewRequest(http.MethodPost, "/cep/batch", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handleCEPBatch(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	var e errorResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Error.Code != "body_too_large" {
+		t.Errorf("code = %q, want body_too_large", e.Error.Code)
+	}
+}
+
+func TestHandleCEPBatch(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if cep := r.URL.Query().Get("cep"); cep == "99999000" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"zipcode_not_found","message":"can not find zipcode"}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"cep":%q}`, r.URL.Query().Get("cep"))
+	}))
+
+	body := `{"ceps":["01001-000","123","99999000","20040020"]}`
+	rec := httptest.NewRecorder()
+	handleCEPBatch(rec, httptest.NewRequest(http.MethodPost, "/cep/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var results []batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ cep, weather, code string }{
+		{"01001000", `{"cep":"01001000"}`, ""},
+		{"123", "", "invalid_zipcode"},
+		{"99999000", "", "zipcode_not_found"},
+		{"20040020", `{"cep":"20040020"}`, ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		code := ""
+		if got.Error != nil {
+			code = got.Error.Code
+		}
+		if got.CEP != w.cep || string(got.Weather) != w.weather || code != w.code {
+			t.Errorf("result %d = {%s %s %s}, want {%s %s %s}", i, got.CEP, got.Weather, code, w.cep, w.weather, w.code)
+		}
+	}
+}
+
+func TestHandleCEPBatchTooMany(t *testing.T) {
+	prev := batchMaxSize
+	batchMaxSize = 2
+	t.Cleanup(func() { batchMaxSize = prev })
+
+	rec := httptest.NewRecorder()
+	body := `{"ceps":["01001000","01001000","01001000"]}`
+	handleCEPBatch(rec, httptest.NewRequest(http.MethodPost, "/cep/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "batch_too_large" {
+		t.Errorf("code = %q, want batch_too_large", e.Error.Code)
+	}
+}
+
+func TestLookupBatchBoundedConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	prev := batchConcurrency
+	batchConcurrency = 2
+	t.Cleanup(func() { batchConcurrency = prev })
+
+	results := lookupBatch(context.Background(), []string{"01001000", "01001001", "01001002", "01001003", "01001004", "01001005"})
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatalf("%s: %+v", r.CEP, r.Error)
+		}
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d calls in flight, want at most 2", p)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker opens after threshold consecutive failures and rejects calls
+// for cooldown. After that a single probe is let through (half-open): success
+// closes the breaker, failure opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	clock     clock
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: max(threshold, 1), cooldown: cooldown, clock: realClock{}}
+}
+
+// allow returns errCircuitOpen when the call must be short-circuited.
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.transition(ctx, breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record reports the outcome of a call let through by allow.
+func (b *circuitBreaker) record(ctx context.Context, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.transition(ctx, breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		if b.state != breakerOpen {
+			b.transition(ctx, breakerOpen)
+		}
+	}
+}
+
+// release ends a call let through by allow without an outcome, as when the
+// caller gave up; a half-open breaker lets the next call probe instead.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// transition must be called with b.mu held.
+func (b *circuitBreaker) transition(ctx context.Context, to breakerState) {
+	trace.SpanFromContext(ctx).AddEvent("circuit breaker state change", trace.WithAttributes(
+		attribute.String("breaker.from", b.state.String()),
+		attribute.String("breaker.to", to.String()),
+	))
+	b.state = to
+}
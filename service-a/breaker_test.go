@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForwardToServiceBCanceledNotAFailure(t *testing.T) {
+	received := make(chan struct{})
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	serviceBBreaker = newCircuitBreaker(1, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-received
+			cancel()
+		}()
+		if _, err := forwardToServiceB(ctx, "01001000", "", ""); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	}
+	if serviceBBreaker.state != breakerClosed {
+		t.Errorf("breaker state = %v after canceled calls, want closed", serviceBBreaker.state)
+	}
+}
+
+func TestBreakerReleaseFreesHalfOpenProbe(t *testing.T) {
+	ctx := context.Background()
+	b := newCircuitBreaker(1, 0)
+	b.allow(ctx)
+	b.record(ctx, false)
+
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("probe allow = %v, want nil", err)
+	}
+	if err := b.allow(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second call during probe = %v, want errCircuitOpen", err)
+	}
+	b.release()
+	if b.state != breakerHalfOpen {
+		t.Errorf("state after release = %v, want half-open", b.state)
+	}
+	if err := b.allow(ctx); err != nil {
+		t.Errorf("allow after release = %v, want a new probe", err)
+	}
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	b := newCircuitBreaker(2, time.Minute)
+	b.clock = clk
+
+	for i := 0; i < 2; i++ {
+		if err := b.allow(ctx); err != nil {
+			t.Fatalf("call %d rejected while closed: %v", i, err)
+		}
+		b.record(ctx, false)
+	}
+	if err := b.allow(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after threshold = %v, want errCircuitOpen", err)
+	}
+
+	clk.Advance(time.Minute - time.Nanosecond)
+	if err := b.allow(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow during cooldown = %v, want errCircuitOpen", err)
+	}
+	clk.Advance(time.Nanosecond)
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("probe after cooldown = %v, want nil", err)
+	}
+	b.record(ctx, true)
+	if b.state != breakerClosed {
+		t.Errorf("state after successful probe = %v, want closed", b.state)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	b := newCircuitBreaker(3, time.Minute)
+	b.clock = clk
+
+	for i := 0; i < 3; i++ {
+		b.allow(ctx)
+		b.record(ctx, false)
+	}
+	clk.Advance(time.Minute)
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("probe after cooldown = %v, want nil", err)
+	}
+	b.record(ctx, false)
+	if b.state != breakerOpen {
+		t.Fatalf("state after failed probe = %v, want open", b.state)
+	}
+	if err := b.allow(ctx); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("allow after failed probe = %v, want a new cooldown", err)
+	}
+}
+
+func TestHandleCEPCircuitOpen(t *testing.T) {
+	var calls atomic.Int32
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	serviceBBreaker = newCircuitBreaker(2, time.Hour)
+
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil))
+		if i < 2 {
+			continue
+		}
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: status = %d, want 503", i, rec.Code)
+		}
+		if e := decodeError(t, rec); e.Error.Code != "service_unavailable" {
+			t.Errorf("request %d: code = %q, want service_unavailable", i, e.Error.Code)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("service-b called %d times, want 2 before the breaker opened", n)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"service-b/platform"
+)
+
+// bulkMaxDuration bounds a whole /cep/bulk request, from the first byte of
+// the upload to the last result, so a client trickling its upload can not
+// hold the connection forever; set from BULK_MAX_DURATION_MS.
+var bulkMaxDuration = 10 * time.Minute
+
+// bulkResult is one line of the /cep/bulk response. Line is the 1-based
+// line of the upload the CEP came from, since results arrive out of order.
+type bulkResult struct {
+	Line int `json:"line"`
+	batchResult
+}
+
+// handleCEPBulk resolves an uploaded list of CEPs, one per line or as the
+// first column of a CSV, and streams back one JSON object per CEP as each
+// lookup completes. The upload is read as the lookups progress, with at most
+// batchConcurrency in flight, so its size is not bounded by memory.
+func handleCEPBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		platform.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != "text/csv" && mediaType != "text/plain") {
+			platform.WriteError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "expected text/csv or text/plain")
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// Results are written while the upload is still being read.
+	if err := rc.EnableFullDuplex(); err != nil {
+		slog.DebugContext(r.Context(), "full duplex unavailable", "error", err)
+	}
+	// Reading the upload is paced by the lookups, so a large one outlasts
+	// platform.ReadTimeout and platform.WriteTimeout. Instead each record
+	// must arrive within platform.ReadTimeout of the previous one being
+	// taken, each result be written within platform.WriteTimeout, and all of
+	// it end by bulkMaxDuration.
+	end := time.Now().Add(bulkMaxDuration)
+	extendDeadline := func(set func(time.Time) error, d time.Duration) {
+		deadline := time.Now().Add(d)
+		if deadline.After(end) {
+			deadline = end
+		}
+		if err := set(deadline); err != nil {
+			slog.DebugContext(r.Context(), "can not set deadline", "error", err)
+		}
+	}
+	extendDeadline(rc.SetReadDeadline, platform.ReadTimeout)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithDeadline(r.Context(), end)
+	defer cancel()
+	type job struct {
+		line int
+		cep  string
+	}
+	jobs := make(chan job)
+	results := make(chan bulkResult)
+
+	var readErr error
+	var wg sync.WaitGroup
+	go func() {
+		defer close(jobs)
+		readErr = readBulkCEPs(r.Body, func(line int, cep string) bool {
+			select {
+			case jobs <- job{line, cep}:
+				extendDeadline(rc.SetReadDeadline, platform.ReadTimeout)
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	for range max(batchConcurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- bulkResult{Line: j.line, batchResult: lookupOne(ctx, j.cep)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	enc := json.NewEncoder(w)
+	failed := false
+	for res := range results {
+		if failed {
+			continue // drain so the workers can exit
+		}
+		extendDeadline(rc.SetWriteDeadline, platform.WriteTimeout)
+		if err := enc.Encode(res); err != nil {
+			failed = true
+			continue
+		}
+		rc.Flush()
+	}
+	if failed {
+		return
+	}
+	// A read timing out also cancels r's context, so it is checked first.
+	switch {
+	case errors.Is(readErr, os.ErrDeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// The upload came in too slowly or for too long; what was read has
+		// been answered. The line itself may be written past the end.
+		rc.SetWriteDeadline(time.Now().Add(platform.WriteTimeout))
+		enc.Encode(bulkResult{batchResult: batchResult{
+			Error: &errorDetail{Code: "request_timeout", Message: "upload too slow"},
+		}})
+	case readErr != nil && ctx.Err() == nil:
+		// The upload stops being read at the first malformed record.
+		res := bulkResult{batchResult: batchResult{
+			Error: &errorDetail{Code: "invalid_request", Message: "invalid request body"},
+		}}
+		var parseErr *csv.ParseError
+		if errors.As(readErr, &parseErr) {
+			res.Line = parseErr.StartLine
+		}
+		enc.Encode(res)
+	}
+}
+
+// readBulkCEPs calls yield with the first field of each non-empty record of
+// body, skipping a "cep" header, until yield returns false or body ends.
+func readBulkCEPs(body io.Reader, yield func(line int, cep string) bool) error {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		cep := strings.TrimSpace(record[0])
+		if cep == "" || (line == 1 && strings.EqualFold(cep, "cep")) {
+			continue
+		}
+		if !yield(line, cep) {
+			return nil
+		}
+	}
+}
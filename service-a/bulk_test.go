@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"service-b/platform"
+)
+
+// withServerTimeouts sets the server timeouts for the duration of the test.
+func withServerTimeouts(t *testing.T, readHeader, read, write, idle time.Duration) {
+	t.Helper()
+	prev := [4]time.Duration{platform.ReadHeaderTimeout, platform.ReadTimeout, platform.WriteTimeout, platform.IdleTimeout}
+	platform.ReadHeaderTimeout, platform.ReadTimeout, platform.WriteTimeout, platform.IdleTimeout = readHeader, read, write, idle
+	t.Cleanup(func() {
+		platform.ReadHeaderTimeout, platform.ReadTimeout, platform.WriteTimeout, platform.IdleTimeout = prev[0], prev[1], prev[2], prev[3]
+	})
+}
+
+// startServer serves handler with platform.NewServer on a loopback port and
+// returns its address.
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := platform.NewServer(ln.Addr().String(), handler)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestReadBulkCEPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantLines []int
+		wantCEPs  []string
+		wantErr   bool
+	}{
+		{"one per line", "01001000\n20040020\n", []int{1, 2}, []string{"01001000", "20040020"}, false},
+		{"csv with header", "cep,name\n01001000,Sé\n20040020,Centro\n", []int{2, 3}, []string{"01001000", "20040020"}, false},
+		{"blank lines and spaces", "\n  01001000 \n\n20040020", []int{2, 4}, []string{"01001000", "20040020"}, false},
+		{"quoted", "\"01001-000\",x\n", []int{1}, []string{"01001-000"}, false},
+		{"header only", "CEP\n", nil, nil, false},
+		{"malformed", "01001000\n\"20040020\n", []int{1}, []string{"01001000"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []int
+			var ceps []string
+			err := readBulkCEPs(strings.NewReader(tt.body), func(line int, cep string) bool {
+				lines, ceps = append(lines, line), append(ceps, cep)
+				return true
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(lines, tt.wantLines) || !slices.Equal(ceps, tt.wantCEPs) {
+				t.Errorf("got lines %v CEPs %v, want %v %v", lines, ceps, tt.wantLines, tt.wantCEPs)
+			}
+		})
+	}
+
+	calls := 0
+	readBulkCEPs(strings.NewReader("01001000\n20040020\n"), func(int, string) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("yield called %d times after returning false, want 1", calls)
+	}
+}
+
+func TestHandleCEPBulk(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"cep":%q}`, r.URL.Query().Get("cep"))
+	}))
+
+	body := "cep,name\n01001000,Sé\n\n123,bad\n20040020,Centro\n"
+	req := httptest.NewRequest(http.MethodPost, "/cep/bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rec := httptest.NewRecorder()
+	handleCEPBulk(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("response = %d %s, want 200 application/x-ndjson", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	got := make(map[int]bulkResult)
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var res bulkResult
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		got[res.Line] = res
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d results, want 3: %s", len(got), rec.Body)
+	}
+	for line, cep := range map[int]string{2: "01001000", 5: "20040020"} {
+		if res := got[line]; res.CEP != cep || res.Error != nil || string(res.Weather) != fmt.Sprintf(`{"cep":%q}`, cep) {
+			t.Errorf("line %d = %+v, want the weather for %s", line, res, cep)
+		}
+	}
+	if res := got[4]; res.CEP != "123" || res.Error == nil || res.Error.Code != "invalid_zipcode" {
+		t.Errorf("line 4 = %+v, want invalid_zipcode for 123", res)
+	}
+}
+
+func TestHandleCEPBulkMalformedUpload(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	rec := httptest.NewRecorder()
+	handleCEPBulk(rec, httptest.NewRequest(http.MethodPost, "/cep/bulk", strings.NewReader("01001000\n\"20040020\n")))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	var last bulkResult
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || last.Error == nil || last.Error.Code != "invalid_request" || last.Line != 2 {
+		t.Errorf("response %q, want one result then invalid_request at line 2", rec.Body)
+	}
+}
+
+func TestHandleCEPBulkErrors(t *testing.T) {
+	tests := []struct {
+		method, contentType string
+		wantStatus          int
+		wantCode            string
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodPost, "application/json", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{http.MethodPost, "text/", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/cep/bulk", strings.NewReader("01001000\n"))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handleCEPBulk(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %q: status = %d, want %d", tt.method, tt.contentType, rec.Code, tt.wantStatus)
+			continue
+		}
+		if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+			t.Errorf("%s %q: code = %q, want %q", tt.method, tt.contentType, e.Error.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestHandleCEPBulkOutlastsServerTimeouts(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"cep":%q}`, r.URL.Query().Get("cep"))
+	}))
+	withServerTimeouts(t, time.Second, 100*time.Millisecond, 100*time.Millisecond, time.Second)
+	addr := startServer(t, http.HandlerFunc(handleCEPBulk))
+
+	// The upload trickles in for longer than the read and write timeouts,
+	// each CEP within the read timeout of the one before.
+	pr, pw := io.Pipe()
+	go func() {
+		for _, cep := range []string{"01001000", "20040020", "30130010", "40010000"} {
+			fmt.Fprintln(pw, cep)
+			time.Sleep(60 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+	resp, err := http.Post("http://"+addr+"/cep/bulk", "text/plain", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var n int
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var res bulkResult
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil || res.Error != nil {
+			t.Errorf("line %q: %v %+v", sc.Text(), err, res.Error)
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil || n != 4 {
+		t.Errorf("got %d results, read error %v; want all 4", n, err)
+	}
+}
+
+func TestHandleCEPBulkStalledUpload(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"cep":%q}`, r.URL.Query().Get("cep"))
+	}))
+	tests := []struct {
+		name        string
+		readTimeout time.Duration
+		maxDuration time.Duration
+		gap         time.Duration
+	}{
+		{"record later than the read timeout", 100 * time.Millisecond, time.Minute, 300 * time.Millisecond},
+		{"upload longer than the maximum duration", time.Second, 150 * time.Millisecond, 60 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServerTimeouts(t, time.Second, tt.readTimeout, time.Second, time.Second)
+			prev := bulkMaxDuration
+			bulkMaxDuration = tt.maxDuration
+			t.Cleanup(func() { bulkMaxDuration = prev })
+			addr := startServer(t, http.HandlerFunc(handleCEPBulk))
+
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go func() {
+				for {
+					if _, err := fmt.Fprintln(pw, "01001000"); err != nil {
+						return
+					}
+					time.Sleep(tt.gap)
+				}
+			}()
+			start := time.Now()
+			resp, err := http.Post("http://"+addr+"/cep/bulk", "text/plain", pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			var last bulkResult
+			var n int
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				last = bulkResult{}
+				if err := json.Unmarshal(sc.Bytes(), &last); err != nil {
+					t.Fatalf("line %q: %v", sc.Text(), err)
+				}
+				n++
+			}
+			if last.Error == nil || last.Error.Code != "request_timeout" || n < 2 {
+				t.Errorf("got %d lines ending in %+v, want results then request_timeout", n, last.Error)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("stalled upload held the request for %v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowedCEPs and deniedCEPs restrict which CEPs are served; set from
+// CEP_ALLOWED_RANGES and CEP_DENIED_RANGES. An empty allow list allows
+// every CEP not denied.
+var allowedCEPs, deniedCEPs cepRanges
+
+// cepRange is an inclusive range of normalized 8-digit CEPs. Since all CEPs
+// have the same length, string comparison orders them numerically.
+type cepRange struct {
+	from, to string
+}
+
+type cepRanges []cepRange
+
+// parseCEPRanges parses a comma-separated list of ranges such as
+// "01000000-19999999" or "01000-000-19999-999", and of prefixes such as
+// "01" that stand for every CEP starting with them.
+func parseCEPRanges(s string) (cepRanges, error) {
+	var ranges cepRanges
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		digits := strings.ReplaceAll(entry, "-", "")
+		if strings.Trim(digits, "0123456789") != "" || digits == "" {
+			return nil, fmt.Errorf("invalid CEP range %q", entry)
+		}
+		switch {
+		case len(digits) == 16:
+			r := cepRange{from: digits[:8], to: digits[8:]}
+			if r.from > r.to {
+				return nil, fmt.Errorf("invalid CEP range %q: start after end", entry)
+			}
+			ranges = append(ranges, r)
+		case len(digits) <= 8:
+			ranges = append(ranges, cepRange{
+				from: digits + strings.Repeat("0", 8-len(digits)),
+				to:   digits + strings.Repeat("9", 8-len(digits)),
+			})
+		default:
+			return nil, fmt.Errorf("invalid CEP range %q", entry)
+		}
+	}
+	return ranges, nil
+}
+
+func (rs cepRanges) contains(cep string) bool {
+	for _, r := range rs {
+		if cep >= r.from && cep <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// cepAllowed reports whether a normalized, valid cep may be served.
+func cepAllowed(cep string) bool {
+	return (len(allowedCEPs) == 0 || allowedCEPs.contains(cep)) && !deniedCEPs.contains(cep)
+}
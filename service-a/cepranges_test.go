@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseCEPRanges(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    cepRanges
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"01000000-19999999", cepRanges{{"01000000", "19999999"}}, false},
+		{"01000-000-19999-999", cepRanges{{"01000000", "19999999"}}, false},
+		{"01", cepRanges{{"01000000", "01999999"}}, false},
+		{"01001000", cepRanges{{"01001000", "01001000"}}, false},
+		{" 2 , 30000000-39999999 ", cepRanges{{"20000000", "29999999"}, {"30000000", "39999999"}}, false},
+		{"19999999-01000000", nil, true},
+		{"0100000", cepRanges{{"01000000", "01000009"}}, false},
+		{"010000000", nil, true},
+		{"01x", nil, true},
+		{"-", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCEPRanges(tt.in)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseCEPRanges(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// withCEPRanges restricts the served CEPs for the duration of the test.
+func withCEPRanges(t *testing.T, allowed, denied string) {
+	t.Helper()
+	prevAllowed, prevDenied := allowedCEPs, deniedCEPs
+	t.Cleanup(func() { allowedCEPs, deniedCEPs = prevAllowed, prevDenied })
+	var err error
+	if allowedCEPs, err = parseCEPRanges(allowed); err != nil {
+		t.Fatal(err)
+	}
+	if deniedCEPs, err = parseCEPRanges(denied); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCEPAllowed(t *testing.T) {
+	tests := []struct {
+		allowed, denied string
+		cep             string
+		want            bool
+	}{
+		{"", "", "69900970", true},
+		{"01000000-19999999", "", "01001000", true},
+		{"01000000-19999999", "", "19999999", true},
+		{"01000000-19999999", "", "20040020", false},
+		{"", "2", "20040020", false},
+		{"", "2", "01001000", true},
+		{"0", "01001", "01001000", false},
+		{"0", "01001", "01310100", true},
+	}
+	for _, tt := range tests {
+		withCEPRanges(t, tt.allowed, tt.denied)
+		if got := cepAllowed(tt.cep); got != tt.want {
+			t.Errorf("allowed %q, denied %q: cepAllowed(%s) = %v, want %v", tt.allowed, tt.denied, tt.cep, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCEPOutsideServedArea(t *testing.T) {
+	withCEPRanges(t, "01000000-19999999", "")
+	var calls atomic.Int32
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+
+	tests := []struct {
+		cep        string
+		wantStatus int
+		wantCalls  int32
+	}{
+		{"01001000", http.StatusOK, 1},
+		{"20040020", http.StatusForbidden, 1},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep="+tt.cep, nil))
+		if n := calls.Load(); rec.Code != tt.wantStatus || n != tt.wantCalls {
+			t.Errorf("cep %s: status = %d after %d service-b calls, want %d after %d", tt.cep, rec.Code, n, tt.wantStatus, tt.wantCalls)
+		}
+		if tt.wantStatus == http.StatusForbidden {
+			if e := decodeError(t, rec); e.Error.Code != "zipcode_not_allowed" || e.Error.Message == "" {
+				t.Errorf("cep %s: error = %+v, want zipcode_not_allowed", tt.cep, e.Error)
+			}
+		}
+	}
+}
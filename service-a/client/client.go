@@ -0,0 +1,143 @@
+// Package client is a Go client for service-a's HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrInvalidZipcode matches errors for CEPs service-a rejects as
+	// malformed.
+	ErrInvalidZipcode = errors.New("invalid zipcode")
+
+	// ErrNotFound matches errors for CEPs that do not exist or whose
+	// location has no weather.
+	ErrNotFound = errors.New("not found")
+)
+
+// WeatherResult is the weather service-a reports for a CEP.
+type WeatherResult struct {
+	CEP             string       `json:"cep"`
+	CEPFormatted    string       `json:"cepFormatted"`
+	City            string       `json:"city"`
+	State           string       `json:"state,omitempty"`
+	Neighborhood    string       `json:"neighborhood,omitempty"`
+	Complement      string       `json:"complement,omitempty"`
+	Coordinates     *Coordinates `json:"coordinates,omitempty"`
+	TempC           float64      `json:"temp_C"`
+	TempF           float64      `json:"temp_F"`
+	TempK           float64      `json:"temp_K"`
+	Humidity        int          `json:"humidity"`
+	WindKph         float64      `json:"wind_kph"`
+	Condition       string       `json:"condition,omitempty"`
+	CEPProvider     string       `json:"cepProvider"`
+	WeatherProvider string       `json:"weatherProvider"`
+
+	// WeatherError is set, and the weather fields are zero, when service-b
+	// runs with PARTIAL_ON_WEATHER_FAILURE and could only find the location.
+	WeatherError string `json:"weatherError,omitempty"`
+}
+
+// Coordinates locate a CEP; they are only reported when the CEP provider has
+// them.
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// APIError is a non-2xx answer from service-a. Use errors.Is with
+// ErrInvalidZipcode or ErrNotFound to test for those cases.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("service-a: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("service-a: %s: %s", e.Code, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalidZipcode:
+		return e.Code == "invalid_zipcode"
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Client calls service-a. The zero value is not usable; create one with New.
+type Client struct {
+	baseURL string
+
+	// HTTPClient sends the requests; it defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// APIKey is sent as X-API-Key when set, for deployments with
+	// REQUIRE_API_KEY.
+	APIKey string
+}
+
+// New returns a Client for the service-a at baseURL, such as
+// "http://localhost:8081".
+func New(baseURL string) *Client {
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// GetWeather returns the weather for cep, which may be formatted like
+// "01001-000".
+func (c *Client) GetWeather(ctx context.Context, cep string) (*WeatherResult, error) {
+	body, err := json.Marshal(map[string]string{"cep": cep})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/cep", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+	var res WeatherResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("service-a: decode response: %w", err)
+	}
+	return &res, nil
+}
+
+// decodeError reads service-a's error envelope, leaving Code empty when the
+// body is not one.
+func decodeError(resp *http.Response) error {
+	var e struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	json.Unmarshal(b, &e)
+	return &APIError{StatusCode: resp.StatusCode, Code: e.Error.Code, Message: e.Error.Message}
+}
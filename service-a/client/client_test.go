@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetWeather(t *testing.T) {
+	var gotMethod, gotPath, gotKey, gotCEP string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotKey = r.Method, r.URL.Path, r.Header.Get("X-API-Key")
+		var body struct {
+			CEP string `json:"cep"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotCEP = body.CEP
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cep":"01001000","cepFormatted":"01001-000","city":"São Paulo","state":"SP",` +
+			`"coordinates":{"lat":-23.55,"lon":-46.63},"temp_C":28.5,"temp_F":83.3,"temp_K":301.65}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	c.APIKey = "secret"
+	res, err := c.GetWeather(context.Background(), "01001-000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/cep" || gotKey != "secret" || gotCEP != "01001-000" {
+		t.Errorf("request = %s %s key %q cep %q; want POST /cep key secret cep 01001-000", gotMethod, gotPath, gotKey, gotCEP)
+	}
+	if res.City != "São Paulo" || res.State != "SP" || res.TempC != 28.5 || res.TempK != 301.65 {
+		t.Errorf("result = %+v", res)
+	}
+	if res.Coordinates == nil || res.Coordinates.Lat != -23.55 {
+		t.Errorf("coordinates = %+v, want lat -23.55", res.Coordinates)
+	}
+}
+
+func TestGetWeatherErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantInvalid  bool
+		wantNotFound bool
+		wantCode     string
+	}{
+		{"invalid zipcode", http.StatusUnprocessableEntity, `{"error":{"code":"invalid_zipcode","message":"invalid zipcode"}}`, true, false, "invalid_zipcode"},
+		{"not found", http.StatusNotFound, `{"error":{"code":"zipcode_not_found","message":"can not find zipcode"}}`, false, true, "zipcode_not_found"},
+		{"bad gateway", http.StatusBadGateway, `{"error":{"code":"bad_gateway","message":"bad gateway"}}`, false, false, "bad_gateway"},
+		{"not an envelope", http.StatusInternalServerError, `oops`, false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := New(srv.URL).GetWeather(context.Background(), "01001000")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.wantCode {
+				t.Errorf("APIError = %d %q, want %d %q", apiErr.StatusCode, apiErr.Code, tt.status, tt.wantCode)
+			}
+			if errors.Is(err, ErrInvalidZipcode) != tt.wantInvalid {
+				t.Errorf("errors.Is(ErrInvalidZipcode) = %v, want %v", !tt.wantInvalid, tt.wantInvalid)
+			}
+			if errors.Is(err, ErrNotFound) != tt.wantNotFound {
+				t.Errorf("errors.Is(ErrNotFound) = %v, want %v", !tt.wantNotFound, tt.wantNotFound)
+			}
+		})
+	}
+}
+
+func TestGetWeatherBadBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"city":`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).GetWeather(context.Background(), "01001000")
+	var apiErr *APIError
+	if err == nil || errors.As(err, &apiErr) {
+		t.Errorf("error = %v, want a decode error", err)
+	}
+}
+
+func TestGetWeatherPartial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"cep":"01001000","city":"São Paulo","state":"SP","weatherError":"weather provider unavailable"}`))
+	}))
+	defer srv.Close()
+
+	res, err := New(srv.URL).GetWeather(context.Background(), "01001000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.City != "São Paulo" || res.WeatherError != "weather provider unavailable" || res.TempC != 0 {
+		t.Errorf("result = %+v, want the location with a WeatherError and no temperatures", res)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// clock abstracts the current time for rate limits, API key quotas, the
+// circuit breaker and the idempotency cache so they can be driven without
+// real waiting.
+type clock interface {
+	Now() time.Time
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"service-b/platform"
+)
+
+// Run modes, set from MODE. modeServiceB runs service-b's own server from
+// this binary; modeCombined serves both services on one port, with calls to
+// service-b handled in process.
+const (
+	modeServiceA = "service-a"
+	modeServiceB = "service-b"
+	modeCombined = "combined"
+)
+
+// inProcessTransport answers requests with handler instead of sending them
+// over the network.
+type inProcessTransport struct {
+	handler http.Handler
+}
+
+func (t inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Handlers may change the request they serve; ours belongs to the caller.
+	req = req.Clone(req.Context())
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	req.RequestURI = req.URL.RequestURI()
+
+	rec := &recordedResponse{header: make(http.Header)}
+	t.handler.ServeHTTP(rec, req)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// newInProcessClient returns a client like platform.NewHTTPClient's whose
+// requests are served by h.
+func newInProcessClient(h http.Handler) *http.Client {
+	return platform.NewHTTPClientWith(inProcessTransport{h})
+}
+
+// mountServiceB serves service-b's /weather on mux for combined mode, behind
+// the same limits, idempotency and CEP range checks as /cep. service-b's
+// other routes, /health/deep among them, stay off the public port.
+func mountServiceB(mux *http.ServeMux, serviceB http.Handler, limit, idempotent func(http.Handler) http.Handler) {
+	mux.Handle("/weather", limit(idempotent(guardWeather(serviceB))))
+}
+
+// guardWeather rejects /weather requests for CEPs outside the served area. It
+// reads the CEPs as service-b does, from the comma-separated cep parameter or
+// the JSON body of a POST, which is left for service-b to read again.
+// Malformed CEPs are left for service-b to reject.
+func guardWeather(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ceps := strings.Split(r.URL.Query().Get("cep"), ",")
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					platform.WriteError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+					return
+				}
+				platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var payload struct {
+				CEP string `json:"cep"`
+			}
+			json.NewDecoder(bytes.NewReader(body)).Decode(&payload)
+			ceps = []string{payload.CEP}
+		}
+		for _, raw := range ceps {
+			if cep, ok := validateCEP(raw); ok && !cepAllowed(cep) {
+				platform.WriteError(w, r, http.StatusForbidden, "zipcode_not_allowed", "zipcode outside the served area")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
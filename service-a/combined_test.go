@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"service-a/weatherpb"
+	"service-b/server"
+)
+
+// withCombinedServiceB serves service-a's calls to service-b in process, as
+// MODE=combined does. serviceBURL points nowhere, so any network call fails.
+func withCombinedServiceB(t *testing.T) http.Handler {
+	t.Helper()
+	t.Setenv("MOCK_MODE", "true")
+	h := server.NewHandler()
+
+	prevURL, prevClient, prevHealthz := serviceBURL, httpClient, healthzTransport
+	serviceBURL = &url.URL{Scheme: "http", Host: "service-b.invalid"}
+	httpClient, healthzTransport = newInProcessClient(h), inProcessTransport{h}
+	t.Cleanup(func() { serviceBURL, httpClient, healthzTransport = prevURL, prevClient, prevHealthz })
+	return h
+}
+
+func TestCombinedModeEndToEnd(t *testing.T) {
+	serviceB := withCombinedServiceB(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cep", handleCEP)
+	mux.HandleFunc("/healthz", handleHealthz)
+	noop := func(h http.Handler) http.Handler { return h }
+	mountServiceB(mux, serviceB, noop, noop)
+
+	t.Run("cep", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01310-100", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+		var out weatherOut
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.CEP != "01310100" || out.City == "" || out.WeatherProvider != "mock" {
+			t.Errorf("response = %+v, want the mock weather for 01310100", out)
+		}
+	})
+
+	t.Run("invalid cep", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=123", nil))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want 422", rec.Code)
+		}
+	})
+
+	t.Run("weather on the same mux", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/weather?cep=01310100", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("healthz", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		res, err := (&grpcServer{}).GetWeather(context.Background(), &weatherpb.CepRequest{Cep: "01310100"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetCity() == "" || res.GetWeatherProvider() != "mock" {
+			t.Errorf("response = %v, want the mock weather", res)
+		}
+	})
+}
+
+func TestMountServiceB(t *testing.T) {
+	serviceB := withCombinedServiceB(t)
+	withCEPRanges(t, "", "2")
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		apiKey     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"allowed cep", http.MethodGet, "/weather?cep=01001000", "", "k", http.StatusOK, ""},
+		{"denied cep", http.MethodGet, "/weather?cep=20040020", "", "k", http.StatusForbidden, "zipcode_not_allowed"},
+		{"denied cep among several", http.MethodGet, "/weather?cep=01001000,%2020040020", "", "k", http.StatusForbidden, "zipcode_not_allowed"},
+		{"allowed cep in a POST body", http.MethodPost, "/weather", `{"cep":"01001000"}`, "k", http.StatusOK, ""},
+		{"denied cep in a POST body", http.MethodPost, "/weather", `{"cep":"20040020"}`, "k", http.StatusForbidden, "zipcode_not_allowed"},
+		{"malformed cep left to service-b", http.MethodGet, "/weather?cep=123", "", "k", http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"no api key", http.MethodGet, "/weather?cep=01001000", "", "", http.StatusUnauthorized, "invalid_api_key"},
+		{"deep health not public", http.MethodGet, "/health/deep", "", "k", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mountServiceB(mux, serviceB, newAPIKeyQuotas(map[string]int{"k": 1}).middleware, newIdempotencyCache(time.Minute).middleware)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(apiKeyHeader, tt.apiKey)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+					t.Errorf("code = %q, want %s", e.Error.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	t.Run("quota shared with the key's other calls", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mountServiceB(mux, serviceB, newAPIKeyQuotas(map[string]int{"k": 1}).middleware, newIdempotencyCache(time.Minute).middleware)
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+			req.Header.Set(apiKeyHeader, "k")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, want)
+			}
+		}
+	})
+}
+
+func TestHandleCEPCarriesCEPFields(t *testing.T) {
+	withCombinedServiceB(t)
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001-000", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var out weatherOut
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.CEP != "01001000" || out.CEPFormatted != "01001-000" {
+		t.Errorf("cep = %q, cepFormatted = %q; want 01001000, 01001-000", out.CEP, out.CEPFormatted)
+	}
+}
+
+func TestInProcessTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok " + r.RequestURI))
+		}, http.StatusOK, "ok /weather?cep=01001000", ""},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "yes")
+			w.WriteHeader(http.StatusNotFound)
+		}, http.StatusNotFound, "", "yes"},
+		{"handler changes the request", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Accept", "changed")
+			r.URL.Path = "/changed"
+		}, http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://service-b.invalid/weather?cep=01001000", nil)
+			req.Header.Set("Accept", "application/json")
+			resp, err := inProcessTransport{tt.handler}.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if got := resp.Header.Get("X-Test"); got != tt.wantHeader {
+				t.Errorf("X-Test = %q, want %q", got, tt.wantHeader)
+			}
+			if resp.ContentLength != int64(len(body)) {
+				t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(body))
+			}
+			if req.Header.Get("Accept") != "application/json" || req.URL.Path != "/weather" {
+				t.Errorf("caller's request changed to %s %v", req.URL.Path, req.Header)
+			}
+		})
+	}
+}
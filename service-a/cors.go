@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"service-b/platform"
+)
+
+// corsPolicy answers browser preflights and sets Access-Control-Allow-Origin
+// for the origins it allows. A "*" entry allows any origin.
+type corsPolicy struct {
+	allowAll bool
+	origins  map[string]bool
+}
+
+// newCORSPolicy parses a comma-separated origin list such as
+// CORS_ALLOWED_ORIGINS.
+func newCORSPolicy(list string) *corsPolicy {
+	p := &corsPolicy{origins: make(map[string]bool)}
+	for _, o := range strings.Split(list, ",") {
+		o = strings.TrimSpace(o)
+		switch o {
+		case "":
+		case "*":
+			p.allowAll = true
+		default:
+			p.origins[o] = true
+		}
+	}
+	return p
+}
+
+func (p *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		switch {
+		case p.allowAll:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case p.origins[origin]:
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		h.Set("Access-Control-Expose-Headers", platform.RequestIDHeader+", "+upstreamDurationHeader+", "+idempotentReplayHeader)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, "+platform.RequestIDHeader+", "+apiKeyHeader)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     string
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantHandled bool
+	}{
+		{"no origin", "https://app.example", http.MethodGet, "", false, http.StatusOK, "", true},
+		{"any origin", "*", http.MethodGet, "https://other.example", false, http.StatusOK, "*", true},
+		{"listed origin", "https://a.example, https://app.example", http.MethodGet, "https://app.example", false, http.StatusOK, "https://app.example", true},
+		{"unlisted origin", "https://app.example", http.MethodGet, "https://evil.example", false, http.StatusOK, "", true},
+		{"preflight", "https://app.example", http.MethodOptions, "https://app.example", true, http.StatusNoContent, "https://app.example", false},
+		{"plain options", "https://app.example", http.MethodOptions, "https://app.example", false, http.StatusOK, "https://app.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			h := newCORSPolicy(tt.allowed).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+			}))
+			req := httptest.NewRequest(tt.method, "/cep", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || handled != tt.wantHandled {
+				t.Errorf("status %d, handled %v; want %d, %v", rec.Code, handled, tt.wantStatus, tt.wantHandled)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.preflight && rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST, OPTIONS" {
+				t.Errorf("Access-Control-Allow-Methods = %q", rec.Header().Get("Access-Control-Allow-Methods"))
+			}
+			if tt.origin != "" && rec.Header().Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin first", rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
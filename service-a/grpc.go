@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"service-a/weatherpb"
+	"service-b/platform"
+)
+
+// weatherOut mirrors service-b's /weather response body.
+type weatherOut struct {
+	CEP          string          `json:"cep"`
+	CEPFormatted string          `json:"cepFormatted"`
+	City         string          `json:"city"`
+	State        string          `json:"state,omitempty"`
+	Neighborhood string          `json:"neighborhood,omitempty"`
+	Complement   string          `json:"complement,omitempty"`
+	Coordinates  *coordinatesOut `json:"coordinates,omitempty"`
+	TempC        float64         `json:"temp_C"`
+	TempF        float64         `json:"temp_F"`
+	TempK        float64         `json:"temp_K"`
+	Humidity     int             `json:"humidity"`
+	WindKph      float64         `json:"wind_kph"`
+
+	CEPProvider     string `json:"cepProvider"`
+	WeatherProvider string `json:"weatherProvider"`
+
+	// WeatherError is set on a partial response that has the location but
+	// no weather.
+	WeatherError string `json:"weatherError,omitempty"`
+}
+
+type coordinatesOut struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// grpcServer serves weatherpb.WeatherService using the same validation and
+// forwarding as handleCEP.
+type grpcServer struct {
+	weatherpb.UnimplementedWeatherServiceServer
+}
+
+// newGRPCServer returns a gRPC server that extracts the OTel trace context
+// from incoming metadata. When quotas is not nil, calls need a known
+// x-api-key in their metadata, as HTTP requests need X-API-Key.
+func newGRPCServer(quotas *apiKeyQuotas) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+	if quotas != nil {
+		opts = append(opts, grpc.UnaryInterceptor(quotas.unaryInterceptor))
+	}
+	s := grpc.NewServer(opts...)
+	weatherpb.RegisterWeatherServiceServer(s, &grpcServer{})
+	return s
+}
+
+func (s *grpcServer) GetWeather(ctx context.Context, req *weatherpb.CepRequest) (*weatherpb.WeatherResponse, error) {
+	cep, ok := validateCEP(req.GetCep())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid zipcode")
+	}
+	if !cepAllowed(cep) {
+		return nil, status.Error(codes.PermissionDenied, "zipcode outside the served area")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	resp, err := forwardToServiceB(ctx, cep, "application/json", "")
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, status.Error(codes.DeadlineExceeded, "gateway timeout")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "bad gateway")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if errors.Is(err, platform.ErrUpstreamTooLarge) {
+		return nil, status.Error(codes.Unavailable, "upstream response too large")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "bad gateway")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if upstreamErrorCode(body) == "location_not_found" {
+			return nil, status.Error(codes.NotFound, "can not find location")
+		}
+		return nil, status.Error(codes.NotFound, "can not find zipcode")
+	case http.StatusUnprocessableEntity:
+		return nil, status.Error(codes.InvalidArgument, "invalid zipcode")
+	default:
+		return nil, status.Errorf(codes.Unavailable, "service-b status %d", resp.StatusCode)
+	}
+
+	var out weatherOut
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, status.Error(codes.Internal, "invalid service-b response")
+	}
+	if out.WeatherError != "" {
+		// WeatherResponse has no way to say the temperatures are missing;
+		// zeros would read as real values.
+		return nil, status.Error(codes.Unavailable, out.WeatherError)
+	}
+	res := &weatherpb.WeatherResponse{
+		Cep:          out.CEP,
+		CepFormatted: out.CEPFormatted,
+		City:         out.City,
+		State:        out.State,
+		Neighborhood: out.Neighborhood,
+		Complement:   out.Complement,
+		TempC:        out.TempC,
+		TempF:        out.TempF,
+		TempK:        out.TempK,
+		Humidity:     int32(out.Humidity),
+		WindKph:      out.WindKph,
+
+		CepProvider:     out.CEPProvider,
+		WeatherProvider: out.WeatherProvider,
+	}
+	if c := out.Coordinates; c != nil {
+		res.Coordinates = &weatherpb.Coordinates{Lat: c.Lat, Lon: c.Lon}
+	}
+	return res, nil
+}
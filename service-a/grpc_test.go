@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"service-a/weatherpb"
+)
+
+func TestGetWeather(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantCode codes.Code
+		wantTemp float64
+	}{
+		{
+			name:     "full response",
+			status:   http.StatusOK,
+			body:     `{"cep":"01001000","city":"São Paulo","temp_C":21.5,"temp_F":70.7,"temp_K":294.65,"humidity":60,"wind_kph":9.4}`,
+			wantCode: codes.OK,
+			wantTemp: 21.5,
+		},
+		{
+			name:     "partial response",
+			status:   http.StatusOK,
+			body:     `{"cep":"01001000","city":"São Paulo","weatherError":"weather provider unavailable"}`,
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:     "unknown zipcode",
+			status:   http.StatusNotFound,
+			body:     `{"error":{"code":"zipcode_not_found","message":"can not find zipcode"}}`,
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "invalid zipcode",
+			status:   http.StatusUnprocessableEntity,
+			body:     `{"error":{"code":"invalid_zipcode","message":"invalid zipcode"}}`,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "service-b failure",
+			status:   http.StatusBadGateway,
+			body:     `{"error":{"code":"bad_gateway","message":"bad gateway"}}`,
+			wantCode: codes.Unavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			res, err := (&grpcServer{}).GetWeather(context.Background(), &weatherpb.CepRequest{Cep: "01001-000"})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if err == nil && res.GetTempC() != tt.wantTemp {
+				t.Errorf("TempC = %v, want %v", res.GetTempC(), tt.wantTemp)
+			}
+		})
+	}
+}
+
+// dialGRPC serves s over an in-memory listener and returns a client for it.
+func dialGRPC(t *testing.T, s *grpc.Server) weatherpb.WeatherServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return weatherpb.NewWeatherServiceClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	var calls atomic.Int32
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cep":"01001000","cepFormatted":"01001-000","city":"São Paulo","state":"SP",` +
+			`"coordinates":{"lat":-23.55,"lon":-46.63},"temp_C":21.5,"temp_F":70.7,"temp_K":294.65,"humidity":60,"wind_kph":9.4}`))
+	}))
+	client := dialGRPC(t, newGRPCServer(nil))
+
+	res, err := client.GetWeather(context.Background(), &weatherpb.CepRequest{Cep: "01001000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetCity() != "São Paulo" || res.GetState() != "SP" || res.GetCepFormatted() != "01001-000" ||
+		res.GetTempK() != 294.65 || res.GetHumidity() != 60 || res.GetCoordinates().GetLat() != -23.55 {
+		t.Errorf("response = %v, want service-b's weather for São Paulo", res)
+	}
+
+	for _, cep := range []string{"", "123", "11111111"} {
+		_, err := client.GetWeather(context.Background(), &weatherpb.CepRequest{Cep: cep})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("GetWeather(%q) = %v, want InvalidArgument", cep, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("service-b called %d times, want 1: invalid CEPs are rejected locally", n)
+	}
+}
+
+func TestGetWeatherNeighborhoodAndComplement(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		wantNeighborhood string
+		wantComplement   string
+	}{
+		{"both", `{"cep":"01001000","city":"São Paulo","neighborhood":"Sé","complement":"lado ímpar","temp_C":20}`, "Sé", "lado ímpar"},
+		{"neighborhood only", `{"cep":"01001000","city":"São Paulo","neighborhood":"Sé","temp_C":20}`, "Sé", ""},
+		{"neither", `{"cep":"01001000","city":"São Paulo","temp_C":20}`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+
+			res, err := (&grpcServer{}).GetWeather(context.Background(), &weatherpb.CepRequest{Cep: "01001000"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.GetNeighborhood() != tt.wantNeighborhood || res.GetComplement() != tt.wantComplement {
+				t.Errorf("neighborhood, complement = %q, %q; want %q, %q",
+					res.GetNeighborhood(), res.GetComplement(), tt.wantNeighborhood, tt.wantComplement)
+			}
+		})
+	}
+}
+
+func TestGetWeatherCoordinates(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *weatherpb.Coordinates
+	}{
+		{"known", `{"cep":"01001000","city":"São Paulo","coordinates":{"lat":-23.5505,"lon":-46.6333},"temp_C":20}`, &weatherpb.Coordinates{Lat: -23.5505, Lon: -46.6333}},
+		{"unknown", `{"cep":"01001000","city":"São Paulo","temp_C":20}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+
+			res, err := (&grpcServer{}).GetWeather(context.Background(), &weatherpb.CepRequest{Cep: "01001000"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := res.GetCoordinates()
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("coordinates = %v, want %v", got, tt.want)
+			}
+			if got != nil && (got.GetLat() != tt.want.GetLat() || got.GetLon() != tt.want.GetLon()) {
+				t.Errorf("coordinates = %v, %v; want %v, %v", got.GetLat(), got.GetLon(), tt.want.GetLat(), tt.want.GetLon())
+			}
+		})
+	}
+}
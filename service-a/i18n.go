@@ -0,0 +1,30 @@
+package main
+
+import "service-b/platform"
+
+// messages holds service-a's translated error messages keyed by language and
+// error code. English messages are the ones passed to platform.WriteError, so
+// only other languages need entries here.
+var messages = map[string]map[string]string{
+	"pt-BR": {
+		"bad_gateway":          "erro ao consultar serviço externo",
+		"body_too_large":       "corpo da requisição muito grande",
+		"gateway_timeout":      "tempo esgotado ao consultar serviço externo",
+		"idempotency_mismatch": "X-Request-ID já usado em outra requisição",
+		"internal_error":       "erro interno",
+		"invalid_api_key":      "API key inválida ou ausente",
+		"invalid_interval":     "valor de interval inválido",
+		"invalid_request":      "corpo da requisição inválido",
+		"invalid_zipcode":      "CEP inválido",
+		"location_not_found":   "localização não encontrada",
+		"method_not_allowed":   "método não permitido",
+		"quota_exceeded":       "cota da API key excedida",
+		"rate_limited":         "muitas requisições",
+		"service_unavailable":  "service-b indisponível",
+		"upstream_too_large":   "resposta do serviço externo muito grande",
+		"zipcode_not_allowed":  "CEP fora da área atendida",
+		"zipcode_not_found":    "CEP não encontrado",
+	},
+}
+
+func init() { platform.AddMessages(messages) }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"service-b/platform"
+)
+
+func TestWriteErrorLocalized(t *testing.T) {
+	tests := []struct {
+		acceptLanguage, code, wantMessage string
+	}{
+		{"pt-BR", "invalid_zipcode", "CEP inválido"},
+		{"en", "invalid_zipcode", "invalid zipcode"},
+		{"pt-BR", "untranslated_code", "invalid zipcode"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		rec := httptest.NewRecorder()
+		platform.WriteError(rec, req, http.StatusUnprocessableEntity, tt.code, "invalid zipcode")
+
+		e := decodeError(t, rec)
+		if e.Error.Code != tt.code || e.Error.Message != tt.wantMessage {
+			t.Errorf("Accept-Language %s, code %s: got %+v, want message %q and the code untranslated",
+				tt.acceptLanguage, tt.code, e.Error, tt.wantMessage)
+		}
+	}
+}
+
+func TestHandleCEPUpstreamErrorInClientLanguage(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// service-b localizes its own messages from the forwarded header.
+		message := "weather provider quota exceeded"
+		if r.Header.Get("Accept-Language") == "pt-BR" {
+			message = "cota do provedor de clima esgotada"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"error":{"code":"upstream_quota_exceeded","message":%q}}`, message)
+	}))
+
+	for lang, want := range map[string]string{"pt-BR": "cota do provedor de clima esgotada", "": "weather provider quota exceeded"} {
+		req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		handleCEP(rec, req)
+
+		e := decodeError(t, rec)
+		if rec.Code != http.StatusServiceUnavailable || e.Error.Code != "upstream_quota_exceeded" || e.Error.Message != want {
+			t.Errorf("Accept-Language %q: %d %+v, want 503 upstream_quota_exceeded %q", lang, rec.Code, e.Error, want)
+		}
+	}
+}
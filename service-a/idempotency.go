@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"service-b/platform"
+)
+
+// idempotentReplayHeader marks a response served from the idempotency cache.
+const idempotentReplayHeader = "X-Idempotent-Replay"
+
+// recordedResponse is a fully buffered response that can be written again.
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recordedResponse) Header() http.Header { return r.header }
+
+func (r *recordedResponse) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *recordedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *recordedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
+
+// errIdempotencyMismatch is returned for an X-Request-ID already used within
+// the ttl for a different request.
+var errIdempotencyMismatch = errors.New("request ID reused for a different request")
+
+type idempotencyEntry struct {
+	fingerprint string
+	done        chan struct{} // closed once resp is set or the entry is abandoned
+	resp        *recordedResponse
+	expires     time.Time
+}
+
+// idempotencyCache replays the response of a request whose X-Request-ID was
+// already seen within ttl. IDs are scoped to the caller's X-API-Key, and a
+// replay must match the first request's method, URI, Accept,
+// Accept-Language and body; a known ID sent with a different request is
+// rejected rather than answered with another request's response. Concurrent
+// requests with the same ID wait for the first one instead of calling
+// service-b again. 5xx responses are handed to
+// the requests waiting on them but not kept, so a later retry goes upstream.
+// When the first request's client goes away before a response is written,
+// the entry is dropped and the waiting requests start over.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	clock   clock
+	entries map[string]*idempotencyEntry
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, clock: realClock{}, entries: make(map[string]*idempotencyEntry)}
+}
+
+// acquire returns the entry for id, creating it for fingerprint when there is
+// no live one. leader is true when the caller created it and must produce the
+// response. A live entry for another fingerprint is errIdempotencyMismatch.
+func (c *idempotencyCache) acquire(id, fingerprint string) (e *idempotencyEntry, leader bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok && (e.resp == nil || c.clock.Now().Before(e.expires)) {
+		if e.fingerprint != fingerprint {
+			return nil, false, errIdempotencyMismatch
+		}
+		return e, false, nil
+	}
+	e = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	c.entries[id] = e
+	return e, true, nil
+}
+
+func (c *idempotencyCache) complete(id string, e *idempotencyEntry, resp *recordedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.resp = resp
+	e.expires = c.clock.Now().Add(c.ttl)
+	if resp.status >= 500 {
+		delete(c.entries, id)
+	}
+	close(e.done)
+}
+
+// abandon drops e without a response, waking the requests waiting on it so
+// they try again.
+func (c *idempotencyCache) abandon(id string, e *idempotencyEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[id] == e {
+		delete(c.entries, id)
+	}
+	close(e.done)
+}
+
+// cleanupLoop drops expired entries every interval until ctx is done.
+func (c *idempotencyCache) cleanupLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.mu.Lock()
+			now := c.clock.Now()
+			for id, e := range c.entries {
+				if e.resp != nil && now.After(e.expires) {
+					delete(c.entries, id)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// middleware applies the cache to requests that carry their own
+// X-Request-ID; generated IDs are unique, so there is nothing to replay.
+func (c *idempotencyCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(platform.RequestIDHeader)
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fingerprint, err := requestFingerprint(r)
+		if err != nil {
+			platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+			return
+		}
+		id += "\x00" + r.Header.Get(apiKeyHeader)
+
+		for {
+			e, leader, err := c.acquire(id, fingerprint)
+			if err != nil {
+				platform.WriteError(w, r, http.StatusUnprocessableEntity, "idempotency_mismatch", "X-Request-ID already used for a different request")
+				return
+			}
+			if leader {
+				trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("idempotency.replayed", false))
+				c.lead(w, r, next, id, e)
+				return
+			}
+
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e.resp == nil {
+				continue // abandoned: the first request's client went away
+			}
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("idempotency.replayed", true))
+			w.Header().Set(idempotentReplayHeader, "true")
+			e.resp.writeTo(w)
+			return
+		}
+	})
+}
+
+// requestFingerprint hashes what makes r a distinct request: its method, URI,
+// the headers that change the response and its body, of which it reads up to
+// maxBodyBytes+1 bytes (enough for the handler to reject a longer one). r's
+// body is left readable from the start.
+func requestFingerprint(r *http.Request) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	h := sha256.New()
+	for _, s := range []string{r.Method, r.URL.RequestURI(), r.Header.Get("Accept"), r.Header.Get("Accept-Language")} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lead produces the response for entry e, which the caller created.
+func (c *idempotencyCache) lead(w http.ResponseWriter, r *http.Request, next http.Handler, id string, e *idempotencyEntry) {
+	resp := &recordedResponse{header: make(http.Header)}
+	finished := false
+	defer func() {
+		// A panicking handler must not leave followers waiting.
+		if !finished {
+			resp.status = http.StatusInternalServerError
+			c.complete(id, e, resp)
+		}
+	}()
+	next.ServeHTTP(resp, r)
+	finished = true
+	if resp.status == 0 && r.Context().Err() != nil {
+		// The handler wrote nothing because the client went away; that is
+		// not an answer to replay.
+		c.abandon(id, e)
+		return
+	}
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	c.complete(id, e, resp)
+	resp.writeTo(w)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"service-b/platform"
+)
+
+func TestIdempotencyClientCanceledLeader(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return // like handleCEP, write nothing for a canceled client
+		}
+		w.Write([]byte("second"))
+	})
+	h := newIdempotencyCache(time.Minute).middleware(next)
+
+	newReq := func(ctx context.Context) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil).WithContext(ctx)
+		req.Header.Set(platform.RequestIDHeader, "abc")
+		return req
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := httptest.NewRecorder()
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		h.ServeHTTP(leader, newReq(ctx))
+	}()
+	<-started
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		defer close(followerDone)
+		h.ServeHTTP(follower, newReq(context.Background()))
+	}()
+
+	cancel()
+	<-leaderDone
+	<-followerDone
+
+	if leader.Body.Len() != 0 {
+		t.Errorf("canceled leader got body %q", leader.Body.String())
+	}
+	if got := follower.Body.String(); got != "second" {
+		t.Errorf("follower body = %q, want %q", got, "second")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler calls = %d, want 2", n)
+	}
+
+	replay := httptest.NewRecorder()
+	h.ServeHTTP(replay, newReq(context.Background()))
+	if replay.Body.String() != "second" || replay.Header().Get(idempotentReplayHeader) != "true" {
+		t.Errorf("replay = %q (replayed %q), want cached %q", replay.Body.String(), replay.Header().Get(idempotentReplayHeader), "second")
+	}
+}
+
+func TestIdempotencyEntryExpires(t *testing.T) {
+	clk := newFakeClock()
+	c := newIdempotencyCache(time.Minute)
+	c.clock = clk
+	var calls atomic.Int32
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("ok"))
+	}))
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+		req.Header.Set(platform.RequestIDHeader, "abc")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do()
+	clk.Advance(time.Minute - time.Nanosecond)
+	if rec := do(); rec.Header().Get(idempotentReplayHeader) != "true" {
+		t.Fatal("request within ttl was not replayed")
+	}
+	clk.Advance(time.Nanosecond)
+	if rec := do(); rec.Header().Get(idempotentReplayHeader) != "" {
+		t.Fatal("request after ttl was replayed")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler calls = %d, want 2", n)
+	}
+}
+
+func TestIdempotencySingleFlight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := newIdempotencyCache(time.Minute).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+
+	const n = 10
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+			req.Header.Set(platform.RequestIDHeader, "abc")
+			h.ServeHTTP(rec, req)
+		}(recs[i])
+	}
+	// Give every request time to reach the cache before the first finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if c := calls.Load(); c != 1 {
+		t.Errorf("handler calls = %d, want 1", c)
+	}
+	replayed := 0
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"city":"São Paulo"}` || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("response %d = %d %q, want the first response", i, rec.Code, rec.Body)
+		}
+		if rec.Header().Get(idempotentReplayHeader) == "true" {
+			replayed++
+		}
+	}
+	if replayed != n-1 {
+		t.Errorf("%d responses marked replayed, want %d", replayed, n-1)
+	}
+}
+
+func TestIdempotencyBypass(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		status    int
+		wantCalls int32
+	}{
+		{"no request ID", "", http.StatusOK, 3},
+		{"server error not kept", "abc", http.StatusBadGateway, 3},
+		{"client error kept", "abc", http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			h := newIdempotencyCache(time.Minute).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+				if tt.requestID != "" {
+					req.Header.Set(platform.RequestIDHeader, tt.requestID)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != tt.status {
+					t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+				}
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyFingerprint(t *testing.T) {
+	type request struct {
+		method, target, body, apiKey string
+	}
+	first := request{http.MethodPost, "/cep", `{"cep":"01001000"}`, "key-a"}
+	tests := []struct {
+		name       string
+		second     request
+		wantStatus int
+		wantReplay bool
+		wantCalls  int32
+	}{
+		{"same request", first, http.StatusOK, true, 1},
+		{"different body", request{http.MethodPost, "/cep", `{"cep":"20040020"}`, "key-a"}, http.StatusUnprocessableEntity, false, 1},
+		{"different method", request{http.MethodGet, "/cep?cep=01001000", "", "key-a"}, http.StatusUnprocessableEntity, false, 1},
+		{"different query", request{http.MethodPost, "/cep?cep=20040020", `{"cep":"01001000"}`, "key-a"}, http.StatusUnprocessableEntity, false, 1},
+		{"another api key", request{http.MethodPost, "/cep", `{"cep":"20040020"}`, "key-b"}, http.StatusOK, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			h := newIdempotencyCache(time.Minute).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				// The handler still gets the whole body.
+				io.Copy(w, r.Body)
+			}))
+			do := func(req request) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(req.method, req.target, strings.NewReader(req.body))
+				r.Header.Set(platform.RequestIDHeader, "abc")
+				r.Header.Set(apiKeyHeader, req.apiKey)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				return rec
+			}
+
+			if rec := do(first); rec.Body.String() != first.body {
+				t.Fatalf("first response = %q, want the echoed body", rec.Body)
+			}
+			rec := do(tt.second)
+			if rec.Code != tt.wantStatus || (rec.Header().Get(idempotentReplayHeader) == "true") != tt.wantReplay {
+				t.Errorf("second response = %d, replayed %q; want %d, replayed %v", rec.Code, rec.Header().Get(idempotentReplayHeader), tt.wantStatus, tt.wantReplay)
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity {
+				if e := decodeError(t, rec); e.Error.Code != "idempotency_mismatch" {
+					t.Errorf("code = %q, want idempotency_mismatch", e.Error.Code)
+				}
+			} else if rec.Body.String() != tt.second.body {
+				t.Errorf("second body = %q, want %q", rec.Body, tt.second.body)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
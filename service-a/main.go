@@ -3,21 +3,31 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
-	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"service-b/platform"
+	"service-b/server"
 )
 
 type cepReq struct {
@@ -26,104 +36,556 @@ type cepReq struct {
 
 var cepRegex = regexp.MustCompile(`^\d{8}$`)
 
+// serviceBURL is where service-b is reached, possibly under a path prefix;
+// set from SERVICE_B_URL.
+var serviceBURL = &url.URL{Scheme: "http", Host: "localhost:8080"}
+
+// strictJSON rejects request bodies with fields service-a does not know;
+// STRICT_JSON=false makes it ignore them instead.
+var strictJSON = true
+
+// upstreamTimeout bounds each outbound request; set from UPSTREAM_TIMEOUT_MS.
+var upstreamTimeout = 10 * time.Second
+
+// maxBodyBytes caps the /cep and /cep/batch request bodies; set from
+// MAX_BODY_BYTES.
+var maxBodyBytes int64 = 4096
+
+// serviceBBreaker guards calls to service-b; configured from
+// BREAKER_FAILURE_THRESHOLD and BREAKER_COOLDOWN_MS.
+var serviceBBreaker = newCircuitBreaker(5, 30*time.Second)
+
+// upstreamDurationHeader is set by service-b and passed through unchanged.
+const upstreamDurationHeader = "X-Upstream-Duration-Ms"
+
+// deadlineHeader tells service-b how many milliseconds are left of the
+// request's deadline, so it does not keep working after service-a gave up.
+const deadlineHeader = "X-Deadline-Ms"
+
+// outputStyleHeader asks service-b for snake_case keys whatever its
+// OUTPUT_STYLE, since weatherOut and the client package decode those.
+const outputStyleHeader = "X-Output-Style"
+
+// httpClient is shared by all calls to service-b; built in main once the pool
+// settings are read.
+var httpClient *http.Client
+
+// healthzTransport carries handleHealthz's check of service-b.
+var healthzTransport http.RoundTripper = http.DefaultTransport
+
+// metrics counts service-a's requests and times its calls to service-b,
+// under the service-a label.
+var metrics = platform.NewMetrics(prometheus.DefaultRegisterer, "service-a")
+
 func main() {
-	exporterEndpoint := getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
-	serviceName := getenv("OTEL_SERVICE_NAME", "service-a")
-	shutdown := setupTracer(exporterEndpoint, serviceName)
+	platform.SetupLogger()
+
+	mode := platform.Getenv("MODE", modeServiceA)
+	switch mode {
+	case modeServiceA, modeCombined:
+	case modeServiceB:
+		server.Main()
+		return
+	default:
+		platform.Fatal("unknown MODE (want service-a, service-b or combined)", "mode", mode)
+	}
+
+	exporterEndpoint := platform.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	serviceName := platform.Getenv("OTEL_SERVICE_NAME", "service-a")
+	shutdown := platform.SetupTracer(exporterEndpoint, serviceName)
 	defer shutdown()
+	shutdownMeter := platform.SetupMeter(exporterEndpoint, serviceName)
+	defer shutdownMeter()
+	metrics.CreateInstruments(otel.GetMeterProvider().Meter(serviceName))
+
+	upstreamTimeout = platform.GetenvDurationMs("UPSTREAM_TIMEOUT_MS", upstreamTimeout)
+	platform.ConfigureHTTPClient()
+	httpClient = platform.NewHTTPClient()
+	strictJSON = os.Getenv("STRICT_JSON") != "false"
+	if maxBodyBytes = int64(platform.GetenvInt("MAX_BODY_BYTES", int(maxBodyBytes))); maxBodyBytes <= 0 {
+		platform.Fatal("invalid MAX_BODY_BYTES: must be greater than 0", "value", maxBodyBytes)
+	}
+	viaCEPBaseURL = strings.TrimRight(platform.Getenv("VIACEP_BASE_URL", viaCEPBaseURL), "/")
+	var err error
+	if serviceBURL, err = parseServiceBURL(platform.Getenv("SERVICE_B_URL", serviceBURL.String())); err != nil {
+		platform.Fatal("invalid SERVICE_B_URL", "error", err)
+	}
+	var serviceB http.Handler
+	if mode == modeCombined {
+		// service-b shares this process, its environment and its providers;
+		// SERVICE_B_URL is not used.
+		serviceB = server.NewHandler()
+		serviceBURL = &url.URL{Scheme: "http", Host: "service-b"}
+		httpClient = newInProcessClient(serviceB)
+		healthzTransport = inProcessTransport{serviceB}
+		slog.Info("combined mode: serving service-b in process")
+	}
+	if allowedCEPs, err = parseCEPRanges(os.Getenv("CEP_ALLOWED_RANGES")); err != nil {
+		platform.Fatal("invalid CEP_ALLOWED_RANGES", "error", err)
+	}
+	if deniedCEPs, err = parseCEPRanges(os.Getenv("CEP_DENIED_RANGES")); err != nil {
+		platform.Fatal("invalid CEP_DENIED_RANGES", "error", err)
+	}
+	if trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		platform.Fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+	serviceBBreaker = newCircuitBreaker(
+		platform.GetenvInt("BREAKER_FAILURE_THRESHOLD", 5),
+		platform.GetenvDurationMs("BREAKER_COOLDOWN_MS", 30*time.Second),
+	)
+
+	var quotas *apiKeyQuotas
+	if os.Getenv("REQUIRE_API_KEY") == "true" {
+		keys, err := parseAPIKeys(os.Getenv("API_KEYS"))
+		if err != nil {
+			platform.Fatal("invalid API_KEYS", "error", err)
+		}
+		if len(keys) == 0 {
+			platform.Fatal("REQUIRE_API_KEY is set but API_KEYS is empty")
+		}
+		quotas = newAPIKeyQuotas(keys)
+	}
+
+	grpcAddr := platform.Getenv("GRPC_ADDR", ":9091")
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		platform.Fatal("failed to listen", "addr", grpcAddr, "error", err)
+	}
+	grpcSrv := newGRPCServer(quotas)
+	go func() {
+		slog.Info("service-a gRPC listening", "addr", lis.Addr().String())
+		if err := grpcSrv.Serve(lis); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	defer grpcSrv.GracefulStop()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	batchConcurrency = platform.GetenvInt("BATCH_CONCURRENCY", batchConcurrency)
+	batchMaxSize = platform.GetenvInt("BATCH_MAX_SIZE", batchMaxSize)
+	bulkMaxDuration = platform.GetenvDurationMs("BULK_MAX_DURATION_MS", bulkMaxDuration)
+
+	limit := func(h http.Handler) http.Handler { return h }
+	if rps := platform.GetenvFloat("RATE_LIMIT_RPS", 0); rps > 0 {
+		limiter := newIPRateLimiter(rps, platform.GetenvInt("RATE_LIMIT_BURST", 10))
+		go limiter.cleanupLoop(ctx, time.Minute)
+		limit = limiter.middleware
+	}
+	if quotas != nil {
+		ipLimit := limit
+		limit = func(h http.Handler) http.Handler { return ipLimit(quotas.middleware(h)) }
+	}
+
+	idempotent := func(h http.Handler) http.Handler { return h }
+	if ttl := time.Duration(platform.GetenvInt("IDEMPOTENCY_TTL_SECONDS", 0)) * time.Second; ttl > 0 {
+		idem := newIdempotencyCache(ttl)
+		go idem.cleanupLoop(ctx, time.Minute)
+		idempotent = idem.middleware
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/cep", otelhttp.NewHandler(http.HandlerFunc(handleCEP), "handleCEP"))
+	mux.Handle("/cep", metrics.InstrumentHandler("cep",
+		limit(otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(idempotent(platform.RecoverPanics(http.HandlerFunc(handleCEP))))), "handleCEP"))))
+	mux.Handle("/cep/batch", metrics.InstrumentHandler("cep_batch",
+		limit(otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleCEPBatch)))), "handleCEPBatch"))))
+	mux.Handle("/cep/bulk", metrics.InstrumentHandler("cep_bulk",
+		limit(otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleCEPBulk)))), "handleCEPBulk"))))
+	mux.Handle("/cep/stream", metrics.InstrumentHandler("cep_stream",
+		limit(otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleCEPStream)))), "handleCEPStream"))))
+	mux.Handle("/validate", metrics.InstrumentHandler("validate",
+		limit(otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleValidate)))), "handleValidate"))))
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/version", handleVersion)
+	mux.Handle("/metrics", promhttp.Handler())
+	if serviceB != nil {
+		mountServiceB(mux, serviceB, limit, idempotent)
+	}
+
+	// CONTENT_SECURITY_POLICY=none drops the header, e.g. when a proxy in
+	// front sets its own.
+	csp := platform.Getenv("CONTENT_SECURITY_POLICY", defaultCSP)
+	if csp == "none" {
+		csp = ""
+	}
+	cors := newCORSPolicy(platform.Getenv("CORS_ALLOWED_ORIGINS", "*"))
+
+	platform.ConfigureServer()
+	srv := platform.NewServer(platform.Getenv("HTTP_ADDR", ":8081"), withSecurityHeaders(csp, cors.middleware(platform.WithGzip(platform.RecoverPanics(mux)))))
+
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		platform.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
 
-	addr := ":8081"
-	log.Printf("service-a listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal(err)
+	if err := platform.Serve(ctx, "service-a", srv, certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		platform.Fatal("server failed", "error", err)
 	}
+	slog.Info("service-a stopped")
 }
 
 func handleCEP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte("method not allowed"))
+	cep, ok := requestCEP(w, r)
+	if !ok {
 		return
 	}
 
-	var payload cepReq
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&payload); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		w.Write([]byte("invalid zipcode"))
+	ctx, cancel := context.WithTimeout(r.Context(), upstreamTimeout)
+	defer cancel()
+
+	var opts []oteltrace.SpanStartOption
+	if sc := inboundSpanContext(r); sc.IsValid() {
+		opts = append(opts, oteltrace.WithLinks(oteltrace.Link{SpanContext: sc}))
+	}
+
+	resp, err := forwardToServiceB(ctx, cep, r.Header.Get("Accept"), r.Header.Get("Accept-Language"), opts...)
+	if err != nil {
+		switch {
+		case platform.ClientCanceled(r, err):
+		case errors.Is(err, errCircuitOpen):
+			platform.WriteError(w, r, http.StatusServiceUnavailable, "service_unavailable", "service-b unavailable")
+		case errors.Is(err, context.DeadlineExceeded):
+			platform.WriteError(w, r, http.StatusGatewayTimeout, "gateway_timeout", "gateway timeout")
+		default:
+			platform.WriteError(w, r, http.StatusBadGateway, "bad_gateway", "bad gateway")
+		}
 		return
 	}
+	defer resp.Body.Close()
 
-	if payload.CEP == "" || !cepRegex.MatchString(payload.CEP) {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		w.Write([]byte("invalid zipcode"))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		switch {
+		case platform.ClientCanceled(r, err):
+		case errors.Is(err, platform.ErrUpstreamTooLarge):
+			platform.WriteError(w, r, http.StatusBadGateway, "upstream_too_large", "upstream response too large")
+		case errors.Is(err, context.DeadlineExceeded):
+			platform.WriteError(w, r, http.StatusGatewayTimeout, "gateway_timeout", "gateway timeout")
+		default:
+			platform.WriteError(w, r, http.StatusBadGateway, "bad_gateway", "bad gateway")
+		}
 		return
 	}
 
-	serviceB := getenv("SERVICE_B_URL", "http://localhost:8080")
-	url := fmt.Sprintf("%s/weather?cep=%s", serviceB, payload.CEP)
+	if resp.StatusCode >= 400 {
+		if e := stableError(resp.StatusCode, upstreamErrorCode(body)); e != nil {
+			platform.WriteError(w, r, resp.StatusCode, e.Code, e.Message)
+			return
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
+	copyResponseHeaders(w.Header(), resp.Header)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// requestCEP reads and validates the CEP of a GET or POST request. On failure
+// it writes the error response and returns false.
+func requestCEP(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		platform.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return "", false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	raw, err := readCEP(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			platform.WriteError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+			return "", false
+		}
+		// The body is not JSON we understand; 422 is kept for a
+		// well-formed body whose CEP is invalid.
+		platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return "", false
+	}
+
+	cep, ok := validateCEP(raw)
+	if !ok {
+		writeInvalidCEP(w, r, cep)
+		return "", false
+	}
+	if !cepAllowed(cep) {
+		platform.WriteError(w, r, http.StatusForbidden, "zipcode_not_allowed", "zipcode outside the served area")
+		return "", false
+	}
+	return cep, true
+}
+
+// hopByHopHeaders only apply to a single connection and must not be
+// forwarded (RFC 9110, section 7.6.1). Content-Length is also left out: the
+// body is re-sent by us and measured again.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyResponseHeaders adds service-b's response headers in src to dst,
+// skipping hop-by-hop headers, those named in src's Connection header and
+// the request ID, which platform.WithRequestID already set.
+func copyResponseHeaders(dst, src http.Header) {
+	skip := map[string]bool{http.CanonicalHeaderKey(platform.RequestIDHeader): true}
+	for _, k := range hopByHopHeaders {
+		skip[k] = true
+	}
+	for _, v := range src.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				skip[http.CanonicalHeaderKey(k)] = true
+			}
+		}
+	}
+	for k, v := range src {
+		if skip[k] {
+			continue
+		}
+		for _, vv := range v {
+			dst.Add(k, vv)
+		}
+	}
+}
 
-	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+// newJSONDecoder returns a decoder for a request body that honors strictJSON.
+func newJSONDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if strictJSON {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+// readCEP extracts the raw CEP from the JSON body or, when the body is empty
+// or has no cep, from the "cep" query parameter. The body wins when both are
+// present.
+func readCEP(r *http.Request) (string, error) {
+	query := r.URL.Query().Get("cep")
+	if r.Method == http.MethodGet && r.ContentLength <= 0 {
+		return query, nil
+	}
+
+	var payload cepReq
+	dec := newJSONDecoder(r.Body)
+	if err := dec.Decode(&payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			return query, nil // empty body
+		}
+		return "", err
+	}
+	if payload.CEP == "" {
+		return query, nil
+	}
+	return payload.CEP, nil
+}
 
-	ctx, span := otel.Tracer("service-a").Start(ctx, "forward to service-b")
+// forwardToServiceB asks service-b for the weather at cep, passing accept
+// through for content negotiation and acceptLanguage for the language of
+// service-b's error messages when set. The caller owns the response body.
+// opts are applied to the forwarding span. It returns errCircuitOpen without
+// calling service-b while serviceBBreaker is open.
+func forwardToServiceB(ctx context.Context, cep, accept, acceptLanguage string, opts ...oteltrace.SpanStartOption) (*http.Response, error) {
+	ctx, span := otel.Tracer("service-a").Start(ctx, "forward to service-b", opts...)
 	defer span.End()
+	span.SetAttributes(attribute.String("cep.code", cep))
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceBEndpoint("weather", url.Values{"cep": {cep}}), nil)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		w.Write([]byte("bad gateway"))
-		return
+		return nil, err
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		w.Write([]byte("bad gateway"))
-		return
+	if id := platform.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(platform.RequestIDHeader, id)
 	}
-	defer resp.Body.Close()
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	req.Header.Set(outputStyleHeader, "snake")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(deadlineHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	// otelhttp's transport injects its client span as well; injecting here
+	// guarantees service-b joins this trace even if the transport changes.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			w.Header().Add(k, vv)
+	if err := serviceBBreaker.allow(ctx); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	metrics.ObserveUpstream("service-b", start)
+	if errors.Is(err, context.Canceled) {
+		// The caller went away; that says nothing about service-b.
+		serviceBBreaker.release()
+	} else {
+		serviceBBreaker.record(ctx, err == nil && resp.StatusCode < 500)
+	}
+	return resp, err
+}
+
+type errorResp struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// fieldError says which input field failed validation and why.
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// inboundSpanContext returns the remote span context carried by the request's
+// traceparent header, if any.
+func inboundSpanContext(r *http.Request) oteltrace.SpanContext {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(r.Header))
+	return oteltrace.SpanContextFromContext(ctx)
+}
+
+// stableError maps the service-b statuses clients act on to fixed,
+// machine-readable errors. upstreamCode, service-b's own error code, only
+// tells an unknown CEP apart from a location the weather provider could not
+// find. It returns nil for any other status.
+func stableError(status int, upstreamCode string) *errorDetail {
+	switch status {
+	case http.StatusUnprocessableEntity:
+		return &errorDetail{Code: "invalid_zipcode", Message: "invalid zipcode"}
+	case http.StatusNotFound:
+		if upstreamCode == "location_not_found" {
+			return &errorDetail{Code: "location_not_found", Message: "can not find location"}
 		}
+		return &errorDetail{Code: "zipcode_not_found", Message: "can not find zipcode"}
 	}
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	return nil
 }
 
-func setupTracer(endpoint, serviceName string) func() {
-	ctx := context.Background()
-	exp, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpointURL(endpoint),
-		otlptracehttp.WithInsecure(),
-	)
+// upstreamErrorCode returns the code from service-b's JSON error envelope, or
+// "" if body is not one.
+func upstreamErrorCode(body []byte) string {
+	var e errorResp
+	json.Unmarshal(body, &e)
+	return e.Error.Code
+}
+
+// writeInvalidCEP answers 422 invalid_zipcode with a details entry saying
+// what is wrong with the normalized cep.
+func writeInvalidCEP(w http.ResponseWriter, r *http.Request, cep string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(errorResp{Error: errorDetail{
+		Code:    "invalid_zipcode",
+		Message: platform.Localize(r, "invalid_zipcode", "invalid zipcode"),
+		Details: []fieldError{{Field: "cep", Reason: cepProblem(cep)}},
+	}})
+}
+
+// validateCEP normalizes raw and reports whether it is a well-formed CEP.
+func validateCEP(raw string) (string, bool) {
+	cep := normalizeCEP(raw)
+	return cep, cepProblem(cep) == ""
+}
+
+// cepProblem describes what makes a normalized cep invalid, or returns ""
+// when it is a well-formed CEP.
+func cepProblem(cep string) string {
+	switch {
+	case cep == "":
+		return "is required"
+	case strings.Trim(cep, "0123456789") != "":
+		return "must contain only digits"
+	case !cepRegex.MatchString(cep):
+		return "must be 8 digits"
+	case allSameDigit(cep):
+		return "can not repeat a single digit"
+	}
+	return ""
+}
+
+// allSameDigit reports whether cep repeats a single digit, like "00000000".
+// No real CEP does, so these are rejected without an upstream call.
+func allSameDigit(cep string) bool {
+	return cep != "" && strings.Count(cep, cep[:1]) == len(cep)
+}
+
+// normalizeCEP strips surrounding whitespace and hyphens so that inputs like
+// "01001-000" or " 01001000 " validate as 8 digits.
+func normalizeCEP(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "-", "")
+}
+
+// parseServiceBURL checks that s is an absolute http(s) URL, as SERVICE_B_URL
+// must be.
+func parseServiceBURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
 	if err != nil {
-		log.Fatalf("failed to create exporter: %v", err)
+		return nil, err
 	}
-	rsrc := resource.NewWithAttributes(
-		semconv.SchemaURL,
-		semconv.ServiceName(serviceName),
-	)
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exp),
-		trace.WithResource(rsrc),
-	)
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.TraceContext{})
-	return func() { _ = tp.Shutdown(context.Background()) }
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%q: scheme must be http or https", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q: missing host", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%q: must not have a query or fragment", s)
+	}
+	return u, nil
+}
+
+// serviceBEndpoint returns the URL of service-b's endpoint p with query q,
+// keeping any path prefix serviceBURL has.
+func serviceBEndpoint(p string, q url.Values) string {
+	u := serviceBURL.JoinPath(p)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
-func getenv(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// handleHealthz reports readiness: service-a is only ready when service-b
+// answers its own /healthz. It is intentionally not traced.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, serviceBEndpoint("healthz", nil), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = (&http.Client{Transport: platform.WithUserAgent(healthzTransport)}).Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("service-b healthz status %d", resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "healthz: service-b unreachable", "error", err)
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status != http.StatusOK {
+		w.Write([]byte(`{"status":"unavailable"}`))
+		return
 	}
-	return def
+	w.Write([]byte(`{"status":"ok"}`))
 }
@@ -0,0 +1,899 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"service-a/client"
+	"service-b/platform"
+)
+
+// withServiceB points service-a at a fake service-b served by h for the
+// duration of the test.
+func withServiceB(t *testing.T, h http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, err := parseServiceBURL(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevURL, prevClient, prevBreaker := serviceBURL, httpClient, serviceBBreaker
+	serviceBURL, httpClient, serviceBBreaker = u, platform.NewHTTPClient(), newCircuitBreaker(5, 30*time.Second)
+	t.Cleanup(func() { serviceBURL, httpClient, serviceBBreaker = prevURL, prevClient, prevBreaker })
+	return srv
+}
+
+func TestForwardToServiceBHeaders(t *testing.T) {
+	var got http.Header
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := forwardToServiceB(ctx, "01001000", "application/xml", "pt-BR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if v := got.Get(outputStyleHeader); v != "snake" {
+		t.Errorf("%s = %q, want snake", outputStyleHeader, v)
+	}
+	if v := got.Get("Accept"); v != "application/xml" {
+		t.Errorf("Accept = %q, want application/xml", v)
+	}
+	if v := got.Get("Accept-Language"); v != "pt-BR" {
+		t.Errorf("Accept-Language = %q, want pt-BR", v)
+	}
+	if ms, err := strconv.Atoi(got.Get(deadlineHeader)); err != nil || ms <= 0 || ms > 60000 {
+		t.Errorf("%s = %q, want the milliseconds left", deadlineHeader, got.Get(deadlineHeader))
+	}
+}
+
+func TestValidateCEP(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"01001000", "01001000", true},
+		{"01001-000", "01001000", true},
+		{" 01001000 ", "01001000", true},
+		{"\t01001-000\n", "01001000", true},
+		{"0100-1000", "01001000", true},
+		{"01001 000", "01001 000", false},
+		{"0100100", "0100100", false},
+		{"010010000", "010010000", false},
+		{"0100100a", "0100100a", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := validateCEP(tt.raw)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("validateCEP(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHandleCEPNormalizesCEP(t *testing.T) {
+	var got string
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("cep")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+
+	for _, body := range []string{`{"cep":"01001-000"}`, `{"cep":" 01001000 "}`} {
+		rec := httptest.NewRecorder()
+		handleCEP(rec, httptest.NewRequest(http.MethodPost, "/cep", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %d, want 200", body, rec.Code)
+		}
+		if got != "01001000" {
+			t.Errorf("POST %s: service-b got cep %q, want 01001000", body, got)
+		}
+	}
+}
+
+// decodeError decodes the JSON error envelope in rec, failing the test when
+// the body is not one.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResp {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var e errorResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("body %q is not a JSON error: %v", rec.Body, err)
+	}
+	return e
+}
+
+func TestHandleCEPErrors(t *testing.T) {
+	notFound := func(code string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":{"code":%q,"message":"not found"}}`, code)
+		}
+	}
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		serviceB   http.HandlerFunc
+		wantStatus int
+		wantCode   string
+	}{
+		{"wrong method", http.MethodDelete, "", nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"malformed body", http.MethodPost, `{"cep":`, nil, http.StatusBadRequest, "invalid_request"},
+		{"invalid cep", http.MethodPost, `{"cep":"123"}`, nil, http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"unknown cep", http.MethodPost, `{"cep":"01001000"}`, notFound("zipcode_not_found"), http.StatusNotFound, "zipcode_not_found"},
+		{"unknown location", http.MethodPost, `{"cep":"01001000"}`, notFound("location_not_found"), http.StatusNotFound, "location_not_found"},
+		{"service-b unreachable", http.MethodPost, `{"cep":"01001000"}`, func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, http.StatusBadGateway, "bad_gateway"},
+		{"service-b invalid cep", http.MethodPost, `{"cep":"01001000"}`, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte("invalid zipcode"))
+		}, http.StatusUnprocessableEntity, "invalid_zipcode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.serviceB
+			if h == nil {
+				h = func(w http.ResponseWriter, r *http.Request) { t.Error("service-b called") }
+			}
+			withServiceB(t, h)
+
+			rec := httptest.NewRecorder()
+			handleCEP(rec, httptest.NewRequest(tt.method, "/cep", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode || e.Error.Message == "" {
+				t.Errorf("error = %+v, want code %q with a message", e.Error, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleCEPBadRequestVersusUnprocessable(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+	prev := strictJSON
+	strictJSON = true
+	t.Cleanup(func() { strictJSON = prev })
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"syntax error", `{"cep":"01001000"`, http.StatusBadRequest, "invalid_request"},
+		{"not an object", `["01001000"]`, http.StatusBadRequest, "invalid_request"},
+		{"wrong type", `{"cep":1001000}`, http.StatusBadRequest, "invalid_request"},
+		{"unknown field", `{"cep":"01001000","zip":"01001000"}`, http.StatusBadRequest, "invalid_request"},
+		{"too short", `{"cep":"0100100"}`, http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"non-digits", `{"cep":"0100100a"}`, http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"valid", `{"cep":"01001000"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleCEP(rec, httptest.NewRequest(http.MethodPost, "/cep", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", e.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleCEPGatewayTimeout(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	prev := upstreamTimeout
+	upstreamTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { upstreamTimeout = prev })
+
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "gateway_timeout" {
+		t.Errorf("code = %q, want gateway_timeout", e.Error.Code)
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceB   http.HandlerFunc
+		wantStatus int
+		wantBody   string
+	}{
+		{"service-b ready", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead || r.URL.Path != "/healthz" {
+				t.Errorf("service-b got %s %s, want HEAD /healthz", r.Method, r.URL.Path)
+			}
+		}, http.StatusOK, `{"status":"ok"}`},
+		{"service-b failing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusServiceUnavailable, `{"status":"unavailable"}`},
+		{"service-b down", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, http.StatusServiceUnavailable, `{"status":"unavailable"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, tt.serviceB)
+			rec := httptest.NewRecorder()
+			handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadCEP(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   string
+	}{
+		{"get query", http.MethodGet, "/cep?cep=01001000", "", "01001000"},
+		{"get without cep", http.MethodGet, "/cep", "", ""},
+		{"post body", http.MethodPost, "/cep", `{"cep":"01001000"}`, "01001000"},
+		{"post empty body uses query", http.MethodPost, "/cep?cep=20040020", "", "20040020"},
+		{"post body without cep uses query", http.MethodPost, "/cep?cep=20040020", `{}`, "20040020"},
+		{"body wins over query", http.MethodPost, "/cep?cep=20040020", `{"cep":"01001000"}`, "01001000"},
+		{"get with body", http.MethodGet, "/cep?cep=20040020", `{"cep":"01001000"}`, "01001000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCEP(httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if err != nil || got != tt.want {
+				t.Errorf("readCEP = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCEPQueryParameter(t *testing.T) {
+	var got string
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("cep")
+	}))
+
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001-000", nil))
+	if rec.Code != http.StatusOK || got != "01001000" {
+		t.Errorf("GET /cep?cep=01001-000: status %d, service-b got %q; want 200 and 01001000", rec.Code, got)
+	}
+}
+
+func TestStableError(t *testing.T) {
+	tests := []struct {
+		status       int
+		upstreamCode string
+		wantCode     string
+	}{
+		{http.StatusUnprocessableEntity, "", "invalid_zipcode"},
+		{http.StatusUnprocessableEntity, "invalid_days", "invalid_zipcode"},
+		{http.StatusNotFound, "zipcode_not_found", "zipcode_not_found"},
+		{http.StatusNotFound, "", "zipcode_not_found"},
+		{http.StatusNotFound, "location_not_found", "location_not_found"},
+		{http.StatusBadGateway, "bad_gateway", ""},
+		{http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		got := stableError(tt.status, tt.upstreamCode)
+		if (got == nil) != (tt.wantCode == "") || (got != nil && got.Code != tt.wantCode) {
+			t.Errorf("stableError(%d, %q) = %+v, want code %q", tt.status, tt.upstreamCode, got, tt.wantCode)
+		}
+	}
+}
+
+func TestUpstreamErrorCode(t *testing.T) {
+	tests := []struct{ body, want string }{
+		{`{"error":{"code":"location_not_found","message":"can not find location"}}`, "location_not_found"},
+		{`can not find zipcode`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := upstreamErrorCode([]byte(tt.body)); got != tt.want {
+			t.Errorf("upstreamErrorCode(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+// captureLogs sends the default logger's JSON records to the returned buffer
+// for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(platform.NewLogHandler(&buf, slog.LevelDebug)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// withGlobalProviders restores the global OTel providers and propagator
+// after the test.
+func withGlobalProviders(t *testing.T) {
+	t.Helper()
+	tp, mp, prop := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+		otel.SetTextMapPropagator(prop)
+	})
+}
+
+func TestHandleCEPGzip(t *testing.T) {
+	body := `{"city":"São Paulo","forecast":[` + strings.Repeat(`{"date":"2024-01-01","min_C":18.5,"max_C":27.1},`, 40) + `{}]}`
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	platform.WithGzip(http.HandlerFunc(handleCEP)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status = %d, Content-Encoding %q; want a gzipped 200", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("Content-Length = %s, the uncompressed length", cl)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("decoded body = %.60q, want service-b's", got)
+	}
+}
+
+func TestHandleCEPMaxBodyBytes(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	prev := maxBodyBytes
+	maxBodyBytes = 32
+	t.Cleanup(func() { maxBodyBytes = prev })
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"cep":"01001000"}`, http.StatusOK},
+		{`{"cep":"01001000"}` + strings.Repeat(" ", 14), http.StatusOK},
+		{`{"cep":"01001000","padding":"` + strings.Repeat("x", 32) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleCEP(rec, httptest.NewRequest(http.MethodPost, "/cep", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%d byte body: status = %d, want %d", len(tt.body), rec.Code, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusRequestEntityTooLarge {
+			if e := decodeError(t, rec); e.Error.Code != "body_too_large" {
+				t.Errorf("code = %q, want body_too_large", e.Error.Code)
+			}
+		}
+	}
+}
+
+func TestForwardToServiceBPropagatesTraceContext(t *testing.T) {
+	withGlobalProviders(t)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "parent")
+	defer span.End()
+	resp, err := forwardToServiceB(ctx, "01001000", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	traceID := span.SpanContext().TraceID().String()
+	if !strings.HasPrefix(traceparent, "00-"+traceID+"-") {
+		t.Errorf("traceparent = %q, want trace %s", traceparent, traceID)
+	}
+	if strings.Contains(traceparent, span.SpanContext().SpanID().String()) {
+		t.Errorf("traceparent = %q names the parent span, want the forwarding span", traceparent)
+	}
+}
+
+func TestForwardToServiceBSpanAttributes(t *testing.T) {
+	withGlobalProviders(t)
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	resp, err := forwardToServiceB(context.Background(), "01001000", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, s := range sr.Ended() {
+		if s.Name() != "forward to service-b" {
+			continue
+		}
+		for _, kv := range s.Attributes() {
+			if kv.Key == "cep.code" && kv.Value.AsString() == "01001000" {
+				return
+			}
+		}
+		t.Fatalf("forwarding span attributes = %v, want cep.code 01001000", s.Attributes())
+	}
+	t.Fatal("no forwarding span")
+}
+
+func TestInboundSpanContext(t *testing.T) {
+	withGlobalProviders(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	req := httptest.NewRequest(http.MethodGet, "/cep", nil)
+	if sc := inboundSpanContext(req); sc.IsValid() {
+		t.Errorf("span context without traceparent = %v, want invalid", sc)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sc := inboundSpanContext(req)
+	if !sc.IsRemote() || sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span context = %+v, want the remote trace 4bf92f…", sc)
+	}
+}
+
+func TestHandleCEPClientCanceled(t *testing.T) {
+	withGlobalProviders(t)
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+
+	ctx, span := otel.Tracer("test").Start(ctx, "GET /cep")
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil).WithContext(ctx))
+	span.End()
+
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("wrote %q to a client that went away", rec.Body)
+	}
+	spans := sr.Ended()
+	if got := spans[len(spans)-1].Status(); got.Code != codes.Error || got.Description != "client canceled request" {
+		t.Errorf("span status = %+v, want an error for the canceled request", got)
+	}
+}
+
+func TestHandleCEPTimeoutVersusBadGateway(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceB   http.HandlerFunc
+		wantStatus int
+		wantCode   string
+	}{
+		{"service-b too slow", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, http.StatusGatewayTimeout, "gateway_timeout"},
+		{"service-b timed out upstream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			w.Write([]byte(`{"error":{"code":"gateway_timeout","message":"gateway timeout"}}`))
+		}, http.StatusGatewayTimeout, "gateway_timeout"},
+		{"service-b connection dropped", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, http.StatusBadGateway, "bad_gateway"},
+	}
+	prev := upstreamTimeout
+	upstreamTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { upstreamTimeout = prev })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, tt.serviceB)
+			rec := httptest.NewRecorder()
+			handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", e.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleCEPPassesUpstreamDuration(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(upstreamDurationHeader, "123")
+	}))
+
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil))
+	if v := rec.Header().Get(upstreamDurationHeader); v != "123" {
+		t.Errorf("%s = %q, want service-b's 123", upstreamDurationHeader, v)
+	}
+}
+
+func TestCEPProblem(t *testing.T) {
+	tests := []struct{ cep, want string }{
+		{"01001000", ""},
+		{"", "is required"},
+		{"0100100a", "must contain only digits"},
+		{"01001 000", "must contain only digits"},
+		{"0100100", "must be 8 digits"},
+		{"010010000", "must be 8 digits"},
+		{"00000000", "can not repeat a single digit"},
+		{"99999999", "can not repeat a single digit"},
+	}
+	for _, tt := range tests {
+		if got := cepProblem(tt.cep); got != tt.want {
+			t.Errorf("cepProblem(%q) = %q, want %q", tt.cep, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCEPInvalidDetails(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { t.Error("service-b called") }))
+
+	rec := httptest.NewRecorder()
+	handleCEP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=11111111", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	e := decodeError(t, rec)
+	if len(e.Error.Details) != 1 || e.Error.Details[0] != (fieldError{Field: "cep", Reason: "can not repeat a single digit"}) {
+		t.Errorf("details = %+v, want the repeated digit reason", e.Error.Details)
+	}
+}
+
+func TestHandleCEPValidationDetails(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { t.Error("service-b called") }))
+
+	tests := []struct {
+		name       string
+		body       string
+		wantReason string
+	}{
+		{"missing", `{}`, "is required"},
+		{"too short", `{"cep":"0100100"}`, "must be 8 digits"},
+		{"too long", `{"cep":"010010000"}`, "must be 8 digits"},
+		{"letters", `{"cep":"01001abc"}`, "must contain only digits"},
+		{"punctuation", `{"cep":"01001.000"}`, "must contain only digits"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleCEP(rec, httptest.NewRequest(http.MethodPost, "/cep", strings.NewReader(tt.body)))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status = %d, want 422", tt.name, rec.Code)
+			continue
+		}
+		e := decodeError(t, rec)
+		want := fieldError{Field: "cep", Reason: tt.wantReason}
+		if e.Error.Code != "invalid_zipcode" || len(e.Error.Details) != 1 || e.Error.Details[0] != want {
+			t.Errorf("%s: error = %+v, want invalid_zipcode with details [%+v]", tt.name, e.Error, want)
+		}
+	}
+}
+
+func TestHandleCEPUpstreamTooLarge(t *testing.T) {
+	prev := platform.MaxUpstreamBytes
+	platform.MaxUpstreamBytes = 64
+	t.Cleanup(func() { platform.MaxUpstreamBytes = prev })
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"within the limit", `{"city":"São Paulo","temp_C":28.5}`, http.StatusOK},
+		{"over the limit", `{"city":"` + strings.Repeat("x", 64) + `"}`, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+
+			rec := httptest.NewRecorder()
+			handleCEP(rec, httptest.NewRequest(http.MethodPost, "/cep", strings.NewReader(`{"cep":"01001000"}`)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusBadGateway {
+				if e := decodeError(t, rec); e.Error.Code != "upstream_too_large" {
+					t.Errorf("code = %q, want upstream_too_large", e.Error.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestParseServiceBURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"http://service-b:8080", false},
+		{"https://weather.example.com/api/", false},
+		{"http://127.0.0.1:8080/prefix", false},
+		{"service-b:8080", true},
+		{"ftp://service-b", true},
+		{"http://", true},
+		{"http://service-b:8080?x=1", true},
+		{"http://service-b:8080#frag", true},
+		{"http://service-b:8080/%zz", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		u, err := parseServiceBURL(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseServiceBURL(%q) = %v, %v; want error %v", tt.in, u, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServiceBEndpoint(t *testing.T) {
+	tests := []struct {
+		base, path string
+		query      url.Values
+		want       string
+	}{
+		{"http://service-b:8080", "/weather", url.Values{"cep": {"01001000"}}, "http://service-b:8080/weather?cep=01001000"},
+		{"http://service-b:8080/", "/weather", url.Values{"cep": {"01001000"}}, "http://service-b:8080/weather?cep=01001000"},
+		{"https://gw.example.com/svc-b", "/weather", url.Values{"cep": {"01001000"}, "days": {"3"}}, "https://gw.example.com/svc-b/weather?cep=01001000&days=3"},
+		{"http://service-b:8080/api/", "/healthz", nil, "http://service-b:8080/api/healthz"},
+	}
+	prev := serviceBURL
+	t.Cleanup(func() { serviceBURL = prev })
+	for _, tt := range tests {
+		u, err := parseServiceBURL(tt.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceBURL = u
+		if got := serviceBEndpoint(tt.path, tt.query); got != tt.want {
+			t.Errorf("base %s: serviceBEndpoint(%q) = %s, want %s", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+	prev := strictJSON
+	t.Cleanup(func() { strictJSON = prev })
+
+	tests := []struct {
+		name       string
+		strict     bool
+		handler    http.HandlerFunc
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"cep strict rejects unknown fields", true, handleCEP, "/cep", `{"cep":"01001000","zip":"x"}`, http.StatusBadRequest},
+		{"cep lenient ignores unknown fields", false, handleCEP, "/cep", `{"cep":"01001000","zip":"x"}`, http.StatusOK},
+		{"cep lenient still rejects bad syntax", false, handleCEP, "/cep", `{"cep":"01001000"`, http.StatusBadRequest},
+		{"batch strict rejects unknown fields", true, handleCEPBatch, "/cep/batch", `{"ceps":["01001000"],"extra":1}`, http.StatusBadRequest},
+		{"batch lenient ignores unknown fields", false, handleCEPBatch, "/cep/batch", `{"ceps":["01001000"],"extra":1}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strictJSON = tt.strict
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if e := decodeError(t, rec); e.Error.Code != "invalid_request" {
+					t.Errorf("code = %q, want invalid_request", e.Error.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyResponseHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      http.Header
+		wantKept []string
+		wantDrop []string
+	}{
+		{
+			name:     "end-to-end headers are kept",
+			src:      http.Header{"Content-Type": {"application/json"}, "Etag": {`"abc"`}, "X-Upstream-Duration-Ms": {"12"}},
+			wantKept: []string{"Content-Type", "Etag", "X-Upstream-Duration-Ms"},
+		},
+		{
+			name: "hop-by-hop headers are dropped",
+			src: http.Header{
+				"Connection": {"keep-alive"}, "Keep-Alive": {"timeout=5"}, "Transfer-Encoding": {"chunked"},
+				"Content-Length": {"42"}, "Upgrade": {"h2c"}, "Te": {"trailers"}, "Trailer": {"Expires"},
+				"Proxy-Authenticate": {"Basic"}, "Content-Type": {"application/json"},
+			},
+			wantKept: []string{"Content-Type"},
+			wantDrop: []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Content-Length", "Upgrade", "Te", "Trailer", "Proxy-Authenticate"},
+		},
+		{
+			name:     "headers named in Connection are dropped",
+			src:      http.Header{"Connection": {"x-internal, X-Debug-Token"}, "X-Internal": {"1"}, "X-Debug-Token": {"t"}, "Cache-Control": {"max-age=60"}},
+			wantKept: []string{"Cache-Control"},
+			wantDrop: []string{"X-Internal", "X-Debug-Token"},
+		},
+		{
+			name:     "request ID is left to withRequestID",
+			src:      http.Header{"X-Request-Id": {"from-service-b"}, "Vary": {"Accept"}},
+			wantKept: []string{"Vary"},
+			wantDrop: []string{"X-Request-Id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := http.Header{}
+			copyResponseHeaders(dst, tt.src)
+			for _, k := range tt.wantKept {
+				if got, want := dst.Values(k), tt.src.Values(k); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+			for _, k := range tt.wantDrop {
+				if v, ok := dst[k]; ok {
+					t.Errorf("%s = %q was forwarded", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyResponseHeadersKeepsMultipleValues(t *testing.T) {
+	dst := http.Header{"Vary": {"Origin"}}
+	copyResponseHeaders(dst, http.Header{"Vary": {"Accept", "X-Output-Style"}})
+	if got := dst.Values("Vary"); fmt.Sprint(got) != "[Origin Accept X-Output-Style]" {
+		t.Errorf("Vary = %q, want service-a's value followed by service-b's", got)
+	}
+}
+
+// TestHandleCEPThroughClient checks that client.Client and the real /cep
+// handler agree on the request and on how each outcome is reported.
+func TestHandleCEPThroughClient(t *testing.T) {
+	var gotCEP atomic.Value
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cep := r.URL.Query().Get("cep")
+		gotCEP.Store(cep)
+		w.Header().Set("Content-Type", "application/json")
+		switch cep {
+		case "01001000":
+			fmt.Fprint(w, `{"cep":"01001000","cepFormatted":"01001-000","city":"São Paulo","state":"SP","temp_C":28.5,"temp_F":83.3,"temp_K":301.5,"cepProvider":"viacep","weatherProvider":"weatherapi"}`)
+		case "12345678":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"zipcode_not_found","message":"can not find zipcode"}}`)
+		case "20040020":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"location_not_found","message":"can not find location"}}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(handleCEP))
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+
+	res, err := c.GetWeather(context.Background(), "01001-000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotCEP.Load() != "01001000" {
+		t.Errorf("service-b asked for %v, want 01001000", gotCEP.Load())
+	}
+	if res.City != "São Paulo" || res.State != "SP" || res.TempC != 28.5 || res.CEPFormatted != "01001-000" || res.CEPProvider != "viacep" {
+		t.Errorf("result = %+v", res)
+	}
+
+	tests := []struct {
+		cep        string
+		wantStatus int
+		wantCode   string
+		wantIs     error
+	}{
+		{"123", http.StatusUnprocessableEntity, "invalid_zipcode", client.ErrInvalidZipcode},
+		{"12345678", http.StatusNotFound, "zipcode_not_found", client.ErrNotFound},
+		{"20040020", http.StatusNotFound, "location_not_found", client.ErrNotFound},
+		{"30130010", http.StatusInternalServerError, "", nil},
+	}
+	for _, tt := range tests {
+		_, err := c.GetWeather(context.Background(), tt.cep)
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: err = %v, want an *APIError", tt.cep, err)
+			continue
+		}
+		if apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode {
+			t.Errorf("%s: error = %d %q, want %d %q", tt.cep, apiErr.StatusCode, apiErr.Code, tt.wantStatus, tt.wantCode)
+		}
+		if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+			t.Errorf("%s: errors.Is(%v, %v) = false", tt.cep, err, tt.wantIs)
+		}
+	}
+}
+func TestRequestIDPropagatedToServiceB(t *testing.T) {
+	var got string
+	withServiceB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(platform.RequestIDHeader)
+		// service-b echoes the ID; it must not be doubled on the response.
+		w.Header().Set(platform.RequestIDHeader, got)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+	req.Header.Set(platform.RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	platform.WithRequestID(http.HandlerFunc(handleCEP)).ServeHTTP(rec, req)
+
+	if got != "abc-123" {
+		t.Errorf("service-b got %s %q, want abc-123", platform.RequestIDHeader, got)
+	}
+	if v := rec.Header().Values(platform.RequestIDHeader); len(v) != 1 || v[0] != "abc-123" {
+		t.Errorf("response %s = %q, want a single abc-123", platform.RequestIDHeader, v)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"service-b/platform"
+)
+
+// rateLimiterIdleTTL is how long a client's bucket is kept after its last
+// request before the cleanup loop drops it.
+const rateLimiterIdleTTL = 3 * time.Minute
+
+type limiterEntry struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter keeps one token bucket per client IP.
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	rps      rate.Limit
+	burst    int
+	clock    clock
+	limiters map[string]*limiterEntry
+}
+
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		rps:      rate.Limit(rps),
+		burst:    burst,
+		clock:    realClock{},
+		limiters: make(map[string]*limiterEntry),
+	}
+}
+
+// allow reports whether ip may make a request now and, if not, how long it
+// should wait before retrying.
+func (l *ipRateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	e, ok := l.limiters[ip]
+	if !ok {
+		e = &limiterEntry{lim: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[ip] = e
+	}
+	now := l.clock.Now()
+	e.lastSeen = now
+	l.mu.Unlock()
+
+	r := e.lim.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Second
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+// cleanupLoop drops idle buckets every interval until ctx is done.
+func (l *ipRateLimiter) cleanupLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			l.mu.Lock()
+			now := l.clock.Now()
+			for ip, e := range l.limiters {
+				if now.Sub(e.lastSeen) > rateLimiterIdleTTL {
+					delete(l.limiters, ip)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// middleware rejects requests over the per-IP limit with 429 and Retry-After.
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			platform.WriteError(w, r, http.StatusTooManyRequests, "rate_limited", "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For is believed; set from
+// TRUSTED_PROXIES. Empty means the header is ignored.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses a comma-separated list of CIDRs such as
+// "10.0.0.0/8" and of single addresses.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedProxy reports whether ip belongs to one of trustedProxies.
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the connection's remote address unless it is a trusted
+// proxy. Then X-Forwarded-For is walked from the right, each hop added by a
+// trusted proxy, and the first untrusted address is the client; values to
+// its left could have been sent by the client itself.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
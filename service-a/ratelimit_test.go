@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPRateLimiterRefill(t *testing.T) {
+	clk := newFakeClock()
+	l := newIPRateLimiter(1, 2)
+	l.clock = clk
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("10.0.0.1"); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+	ok, wait := l.allow("10.0.0.1")
+	if ok || wait != time.Second {
+		t.Fatalf("request over burst = ok %v, wait %v; want rejected for 1s", ok, wait)
+	}
+	if ok, _ := l.allow("10.0.0.2"); !ok {
+		t.Fatal("another IP shares the exhausted bucket")
+	}
+	clk.Advance(time.Second)
+	if ok, _ := l.allow("10.0.0.1"); !ok {
+		t.Fatal("request rejected after the bucket refilled")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	prev := trustedProxies
+	t.Cleanup(func() { trustedProxies = prev })
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.0/8, 192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no proxy", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer spoofing", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:5000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"client-supplied prefix ignored", "10.0.0.5:5000", []string{"1.2.3.4, 198.51.100.9"}, "198.51.100.9"},
+		{"chain of trusted proxies", "192.168.1.1:5000", []string{"198.51.100.9, 10.1.2.3"}, "198.51.100.9"},
+		{"repeated headers", "10.0.0.5:5000", []string{"1.2.3.4", "198.51.100.9"}, "198.51.100.9"},
+		{"only trusted hops", "10.0.0.5:5000", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"trusted proxy without header", "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"ipv6 peer", "[2001:db8::1]:5000", []string{"1.2.3.4"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cep", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8,x"} {
+		if _, err := parseTrustedProxies(s); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded, want an error", s)
+		}
+	}
+	p, err := parseTrustedProxies("")
+	if err != nil || len(p) != 0 {
+		t.Errorf("parseTrustedProxies(\"\") = %v, %v; want none", p, err)
+	}
+}
+
+func TestIPRateLimiterMiddleware(t *testing.T) {
+	l := newIPRateLimiter(0.5, 1)
+	l.clock = newFakeClock()
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want 200", rec.Code)
+	}
+	rec := serve("10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want 429", rec.Code)
+	}
+	if v := rec.Header().Get("Retry-After"); v != "2" {
+		t.Errorf("Retry-After = %q, want 2", v)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "rate_limited" {
+		t.Errorf("code = %q, want rate_limited", e.Error.Code)
+	}
+	if rec := serve("10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other IP: status = %d, want 200", rec.Code)
+	}
+}
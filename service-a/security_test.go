@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"service-b/platform"
+)
+
+func TestWithSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		csp     string
+		status  int
+		wantCSP string
+	}{
+		{"default policy", defaultCSP, http.StatusOK, defaultCSP},
+		{"custom policy", "default-src 'self'", http.StatusOK, "default-src 'self'"},
+		{"no policy", "", http.StatusOK, ""},
+		{"error responses too", defaultCSP, http.StatusNotFound, defaultCSP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withSecurityHeaders(tt.csp, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cep?cep=01001000", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			want := map[string]string{
+				"X-Content-Type-Options": "nosniff",
+				"X-Frame-Options":        "DENY",
+				"Referrer-Policy":        "no-referrer",
+			}
+			for k, v := range want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+			if got, ok := rec.Header()["Content-Security-Policy"]; tt.wantCSP == "" && ok {
+				t.Errorf("Content-Security-Policy = %q, want it omitted", got)
+			} else if tt.wantCSP != "" && rec.Header().Get("Content-Security-Policy") != tt.wantCSP {
+				t.Errorf("Content-Security-Policy = %q, want %q", rec.Header().Get("Content-Security-Policy"), tt.wantCSP)
+			}
+		})
+	}
+}
+
+func TestWithSecurityHeadersOnPanic(t *testing.T) {
+	h := withSecurityHeaders(defaultCSP, platform.RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	rec := httptest.NewRecorder()
+	captureLogs(t)
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cep", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("status = %d, X-Content-Type-Options = %q; want 500 with nosniff", rec.Code, rec.Header().Get("X-Content-Type-Options"))
+	}
+}
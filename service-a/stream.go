@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"service-b/platform"
+)
+
+const (
+	// minStreamInterval is the shortest refresh /cep/stream allows, so one
+	// client cannot hammer service-b.
+	minStreamInterval = 5 * time.Second
+
+	defaultStreamInterval = 30 * time.Second
+)
+
+// handleCEPStream pushes the weather for one CEP as Server-Sent Events: one
+// "weather" event right away and another every interval seconds, until the
+// client disconnects. Failed lookups are sent as "error" events carrying the
+// usual error object, and the stream goes on.
+func handleCEPStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		platform.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	cep, ok := validateCEP(r.URL.Query().Get("cep"))
+	if !ok {
+		writeInvalidCEP(w, r, cep)
+		return
+	}
+	if !cepAllowed(cep) {
+		platform.WriteError(w, r, http.StatusForbidden, "zipcode_not_allowed", "zipcode outside the served area")
+		return
+	}
+	interval, err := parseStreamInterval(r.URL.Query().Get("interval"))
+	if err != nil {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_interval", "invalid interval")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream lasts until the client leaves, far past platform.WriteTimeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.DebugContext(r.Context(), "can not lift write deadline", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res := lookupOne(ctx, cep)
+		event, data := "weather", bytes.TrimSpace(res.Weather)
+		if res.Error != nil {
+			event, data = "error", mustJSON(res.Error)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseStreamInterval reads the interval parameter in seconds. Empty means
+// defaultStreamInterval; shorter values are raised to minStreamInterval.
+func parseStreamInterval(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultStreamInterval, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", v)
+	}
+	return max(time.Duration(secs)*time.Second, minStreamInterval), nil
+}
+
+func mustJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreamInterval(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultStreamInterval, false},
+		{"60", time.Minute, false},
+		{"5", minStreamInterval, false},
+		{"1", minStreamInterval, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"1.5", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStreamInterval(tt.v)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseStreamInterval(%q) = %v, %v; want %v, error %v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// readEvent reads one Server-Sent Event from r.
+func readEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestHandleCEPStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		serviceB  http.HandlerFunc
+		wantEvent string
+		wantData  string
+	}{
+		{"weather", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"city":"São Paulo"}` + "\n"))
+		}, "weather", `{"city":"São Paulo"}`},
+		{"error", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"zipcode_not_found","message":"not found"}}`))
+		}, "error", `{"code":"zipcode_not_found","message":"can not find zipcode"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServiceB(t, tt.serviceB)
+			done := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				handleCEPStream(w, r)
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/cep/stream?cep=01001000&interval=5", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+				t.Fatalf("response = %d %s, want 200 text/event-stream", resp.StatusCode, ct)
+			}
+
+			event, data := readEvent(t, bufio.NewReader(resp.Body))
+			if event != tt.wantEvent || data != tt.wantData {
+				t.Errorf("event = %s %s, want %s %s", event, data, tt.wantEvent, tt.wantData)
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Error("handler still streaming after the client left")
+			}
+		})
+	}
+}
+
+func TestHandleCEPStreamErrors(t *testing.T) {
+	tests := []struct {
+		method, target string
+		wantStatus     int
+		wantCode       string
+	}{
+		{http.MethodPost, "/cep/stream?cep=01001000", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/cep/stream?cep=123", http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{http.MethodGet, "/cep/stream?cep=01001000&interval=0", http.StatusUnprocessableEntity, "invalid_interval"},
+		{http.MethodGet, "/cep/stream?cep=01001000&interval=x", http.StatusUnprocessableEntity, "invalid_interval"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleCEPStream(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, rec.Code, tt.wantStatus)
+			continue
+		}
+		if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+			t.Errorf("%s %s: code = %q, want %q", tt.method, tt.target, e.Error.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	"service-b/platform"
+)
+
+// viaCEPBaseURL is used by /validate?lookup=true; set from VIACEP_BASE_URL.
+var viaCEPBaseURL = "https://viacep.com.br/ws"
+
+var errZipcodeNotFound = errors.New("zipcode not found")
+
+type validateResp struct {
+	Valid bool   `json:"valid"`
+	CEP   string `json:"cep"`
+	City  string `json:"city,omitempty"`
+	State string `json:"state,omitempty"`
+}
+
+// handleValidate checks a CEP without fetching the weather. By default it
+// only normalizes and validates the format; with lookup=true it also asks
+// viacep whether the CEP exists, answering 404 when it does not.
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	cep, ok := requestCEP(w, r)
+	if !ok {
+		return
+	}
+	resp := validateResp{Valid: true, CEP: cep}
+
+	if lookup, _ := strconv.ParseBool(r.URL.Query().Get("lookup")); lookup {
+		ctx, cancel := context.WithTimeout(r.Context(), upstreamTimeout)
+		defer cancel()
+
+		city, uf, err := lookupViaCEP(ctx, cep)
+		switch {
+		case errors.Is(err, errZipcodeNotFound):
+			platform.WriteError(w, r, http.StatusNotFound, "zipcode_not_found", "can not find zipcode")
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			platform.WriteError(w, r, http.StatusGatewayTimeout, "gateway_timeout", "gateway timeout")
+			return
+		case errors.Is(err, platform.ErrUpstreamTooLarge):
+			platform.WriteError(w, r, http.StatusBadGateway, "upstream_too_large", "upstream response too large")
+			return
+		case err != nil:
+			platform.WriteError(w, r, http.StatusBadGateway, "bad_gateway", "bad gateway")
+			return
+		}
+		resp.City, resp.State = city, uf
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// lookupViaCEP returns the city and UF viacep has for cep.
+func lookupViaCEP(ctx context.Context, cep string) (city, uf string, err error) {
+	ctx, span := otel.Tracer("service-a").Start(ctx, "viaCEP lookup")
+	defer span.End()
+	defer metrics.ObserveUpstream("viacep", time.Now())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/json/", viaCEPBaseURL, cep), nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("viacep status %d", resp.StatusCode)
+	}
+
+	var v struct {
+		Localidade string `json:"localidade"`
+		UF         string `json:"uf"`
+		Erro       any    `json:"erro"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", "", err
+	}
+	if v.Erro != nil || v.Localidade == "" {
+		return "", "", errZipcodeNotFound
+	}
+	return v.Localidade, v.UF, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"service-b/platform"
+)
+
+// withViaCEP points viacep lookups at a test server running h.
+func withViaCEP(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	prevURL, prevClient := viaCEPBaseURL, httpClient
+	viaCEPBaseURL, httpClient = srv.URL, platform.NewHTTPClient()
+	t.Cleanup(func() { viaCEPBaseURL, httpClient = prevURL, prevClient })
+}
+
+func TestHandleValidate(t *testing.T) {
+	prevTimeout := upstreamTimeout
+	upstreamTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { upstreamTimeout = prevTimeout })
+
+	var lookups atomic.Int32
+	withViaCEP(t, func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		switch r.URL.Path {
+		case "/01001000/json/":
+			w.Write([]byte(`{"cep":"01001-000","localidade":"São Paulo","uf":"SP"}`))
+		case "/01001001/json/":
+			w.Write([]byte(`{"erro":true}`))
+		case "/01001002/json/":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/01001003/json/":
+			<-r.Context().Done()
+		default:
+			t.Errorf("unexpected viacep path %s", r.URL.Path)
+		}
+	})
+
+	tests := []struct {
+		target      string
+		wantStatus  int
+		wantCode    string
+		want        validateResp
+		wantLookups int32
+	}{
+		{"/validate?cep=01001-000", http.StatusOK, "", validateResp{Valid: true, CEP: "01001000"}, 0},
+		{"/validate?cep=01001000&lookup=false", http.StatusOK, "", validateResp{Valid: true, CEP: "01001000"}, 0},
+		{"/validate?cep=01001000&lookup=true", http.StatusOK, "", validateResp{Valid: true, CEP: "01001000", City: "São Paulo", State: "SP"}, 1},
+		{"/validate?cep=01001001&lookup=true", http.StatusNotFound, "zipcode_not_found", validateResp{}, 1},
+		{"/validate?cep=01001002&lookup=true", http.StatusBadGateway, "bad_gateway", validateResp{}, 1},
+		{"/validate?cep=01001003&lookup=true", http.StatusGatewayTimeout, "gateway_timeout", validateResp{}, 1},
+		{"/validate?cep=123&lookup=true", http.StatusUnprocessableEntity, "invalid_zipcode", validateResp{}, 0},
+	}
+	for _, tt := range tests {
+		lookups.Store(0)
+		rec := httptest.NewRecorder()
+		handleValidate(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", tt.target, rec.Code, tt.wantStatus, rec.Body)
+			continue
+		}
+		if n := lookups.Load(); n != tt.wantLookups {
+			t.Errorf("%s: %d viacep lookups, want %d", tt.target, n, tt.wantLookups)
+		}
+		if tt.wantCode != "" {
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("%s: code = %q, want %q", tt.target, e.Error.Code, tt.wantCode)
+			}
+			continue
+		}
+		var got validateResp
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestHandleValidatePost(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleValidate(rec, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`{"cep":"01001000"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got validateResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Valid || got.CEP != "01001000" {
+		t.Errorf("got %+v, want a valid 01001000", got)
+	}
+}
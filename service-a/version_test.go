@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleVersion(t *testing.T) {
+	prevVersion, prevCommit, prevBuildTime := version, commit, buildTime
+	version, commit, buildTime = "1.2.3", "abc1234", "2024-01-01T00:00:00Z"
+	t.Cleanup(func() { version, commit, buildTime = prevVersion, prevCommit, prevBuildTime })
+
+	rec := httptest.NewRecorder()
+	handleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got versionResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := versionResp{Version: "1.2.3", Commit: "abc1234", BuildTime: "2024-01-01T00:00:00Z"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,4 @@
+// Package weatherpb holds the gRPC API served by service-a.
+package weatherpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative weather.proto
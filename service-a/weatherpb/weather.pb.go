@@ -0,0 +1,353 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: weather.proto
+
+package weatherpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CepRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cep           string                 `protobuf:"bytes,1,opt,name=cep,proto3" json:"cep,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CepRequest) Reset() {
+	*x = CepRequest{}
+	mi := &file_weather_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CepRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CepRequest) ProtoMessage() {}
+
+func (x *CepRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_weather_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CepRequest.ProtoReflect.Descriptor instead.
+func (*CepRequest) Descriptor() ([]byte, []int) {
+	return file_weather_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CepRequest) GetCep() string {
+	if x != nil {
+		return x.Cep
+	}
+	return ""
+}
+
+type WeatherResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	City            string                 `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	TempC           float64                `protobuf:"fixed64,2,opt,name=temp_c,json=tempC,proto3" json:"temp_c,omitempty"`
+	TempF           float64                `protobuf:"fixed64,3,opt,name=temp_f,json=tempF,proto3" json:"temp_f,omitempty"`
+	TempK           float64                `protobuf:"fixed64,4,opt,name=temp_k,json=tempK,proto3" json:"temp_k,omitempty"`
+	Humidity        int32                  `protobuf:"varint,5,opt,name=humidity,proto3" json:"humidity,omitempty"`
+	WindKph         float64                `protobuf:"fixed64,6,opt,name=wind_kph,json=windKph,proto3" json:"wind_kph,omitempty"`
+	State           string                 `protobuf:"bytes,7,opt,name=state,proto3" json:"state,omitempty"`
+	Cep             string                 `protobuf:"bytes,8,opt,name=cep,proto3" json:"cep,omitempty"`
+	CepFormatted    string                 `protobuf:"bytes,9,opt,name=cep_formatted,json=cepFormatted,proto3" json:"cep_formatted,omitempty"`
+	CepProvider     string                 `protobuf:"bytes,10,opt,name=cep_provider,json=cepProvider,proto3" json:"cep_provider,omitempty"`
+	WeatherProvider string                 `protobuf:"bytes,11,opt,name=weather_provider,json=weatherProvider,proto3" json:"weather_provider,omitempty"`
+	Neighborhood    string                 `protobuf:"bytes,12,opt,name=neighborhood,proto3" json:"neighborhood,omitempty"`
+	Complement      string                 `protobuf:"bytes,13,opt,name=complement,proto3" json:"complement,omitempty"`
+	// coordinates is unset when the CEP provider has none.
+	Coordinates   *Coordinates `protobuf:"bytes,14,opt,name=coordinates,proto3" json:"coordinates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WeatherResponse) Reset() {
+	*x = WeatherResponse{}
+	mi := &file_weather_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WeatherResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WeatherResponse) ProtoMessage() {}
+
+func (x *WeatherResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_weather_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WeatherResponse.ProtoReflect.Descriptor instead.
+func (*WeatherResponse) Descriptor() ([]byte, []int) {
+	return file_weather_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *WeatherResponse) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetTempC() float64 {
+	if x != nil {
+		return x.TempC
+	}
+	return 0
+}
+
+func (x *WeatherResponse) GetTempF() float64 {
+	if x != nil {
+		return x.TempF
+	}
+	return 0
+}
+
+func (x *WeatherResponse) GetTempK() float64 {
+	if x != nil {
+		return x.TempK
+	}
+	return 0
+}
+
+func (x *WeatherResponse) GetHumidity() int32 {
+	if x != nil {
+		return x.Humidity
+	}
+	return 0
+}
+
+func (x *WeatherResponse) GetWindKph() float64 {
+	if x != nil {
+		return x.WindKph
+	}
+	return 0
+}
+
+func (x *WeatherResponse) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetCep() string {
+	if x != nil {
+		return x.Cep
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetCepFormatted() string {
+	if x != nil {
+		return x.CepFormatted
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetCepProvider() string {
+	if x != nil {
+		return x.CepProvider
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetWeatherProvider() string {
+	if x != nil {
+		return x.WeatherProvider
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetNeighborhood() string {
+	if x != nil {
+		return x.Neighborhood
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetComplement() string {
+	if x != nil {
+		return x.Complement
+	}
+	return ""
+}
+
+func (x *WeatherResponse) GetCoordinates() *Coordinates {
+	if x != nil {
+		return x.Coordinates
+	}
+	return nil
+}
+
+type Coordinates struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Coordinates) Reset() {
+	*x = Coordinates{}
+	mi := &file_weather_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Coordinates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Coordinates) ProtoMessage() {}
+
+func (x *Coordinates) ProtoReflect() protoreflect.Message {
+	mi := &file_weather_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Coordinates.ProtoReflect.Descriptor instead.
+func (*Coordinates) Descriptor() ([]byte, []int) {
+	return file_weather_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Coordinates) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Coordinates) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+var File_weather_proto protoreflect.FileDescriptor
+
+const file_weather_proto_rawDesc = "" +
+	"\n" +
+	"\rweather.proto\x12\x06cep.v1\"\x1e\n" +
+	"\n" +
+	"CepRequest\x12\x10\n" +
+	"\x03cep\x18\x01 \x01(\tR\x03cep\"\xb7\x03\n" +
+	"\x0fWeatherResponse\x12\x12\n" +
+	"\x04city\x18\x01 \x01(\tR\x04city\x12\x15\n" +
+	"\x06temp_c\x18\x02 \x01(\x01R\x05tempC\x12\x15\n" +
+	"\x06temp_f\x18\x03 \x01(\x01R\x05tempF\x12\x15\n" +
+	"\x06temp_k\x18\x04 \x01(\x01R\x05tempK\x12\x1a\n" +
+	"\bhumidity\x18\x05 \x01(\x05R\bhumidity\x12\x19\n" +
+	"\bwind_kph\x18\x06 \x01(\x01R\awindKph\x12\x14\n" +
+	"\x05state\x18\a \x01(\tR\x05state\x12\x10\n" +
+	"\x03cep\x18\b \x01(\tR\x03cep\x12#\n" +
+	"\rcep_formatted\x18\t \x01(\tR\fcepFormatted\x12!\n" +
+	"\fcep_provider\x18\n" +
+	" \x01(\tR\vcepProvider\x12)\n" +
+	"\x10weather_provider\x18\v \x01(\tR\x0fweatherProvider\x12\"\n" +
+	"\fneighborhood\x18\f \x01(\tR\fneighborhood\x12\x1e\n" +
+	"\n" +
+	"complement\x18\r \x01(\tR\n" +
+	"complement\x125\n" +
+	"\vcoordinates\x18\x0e \x01(\v2\x13.cep.v1.CoordinatesR\vcoordinates\"1\n" +
+	"\vCoordinates\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon2K\n" +
+	"\x0eWeatherService\x129\n" +
+	"\n" +
+	"GetWeather\x12\x12.cep.v1.CepRequest\x1a\x17.cep.v1.WeatherResponseB\x15Z\x13service-a/weatherpbb\x06proto3"
+
+var (
+	file_weather_proto_rawDescOnce sync.Once
+	file_weather_proto_rawDescData []byte
+)
+
+func file_weather_proto_rawDescGZIP() []byte {
+	file_weather_proto_rawDescOnce.Do(func() {
+		file_weather_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_weather_proto_rawDesc), len(file_weather_proto_rawDesc)))
+	})
+	return file_weather_proto_rawDescData
+}
+
+var file_weather_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_weather_proto_goTypes = []any{
+	(*CepRequest)(nil),      // 0: cep.v1.CepRequest
+	(*WeatherResponse)(nil), // 1: cep.v1.WeatherResponse
+	(*Coordinates)(nil),     // 2: cep.v1.Coordinates
+}
+var file_weather_proto_depIdxs = []int32{
+	2, // 0: cep.v1.WeatherResponse.coordinates:type_name -> cep.v1.Coordinates
+	0, // 1: cep.v1.WeatherService.GetWeather:input_type -> cep.v1.CepRequest
+	1, // 2: cep.v1.WeatherService.GetWeather:output_type -> cep.v1.WeatherResponse
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_weather_proto_init() }
+func file_weather_proto_init() {
+	if File_weather_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_weather_proto_rawDesc), len(file_weather_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_weather_proto_goTypes,
+		DependencyIndexes: file_weather_proto_depIdxs,
+		MessageInfos:      file_weather_proto_msgTypes,
+	}.Build()
+	File_weather_proto = out.File
+	file_weather_proto_goTypes = nil
+	file_weather_proto_depIdxs = nil
+}
@@ -0,0 +1,59 @@
+package platform
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Getenv reads k, falling back to def when it is unset or empty.
+func Getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+// GetenvInt reads k as an integer, falling back to def when it is unset or invalid.
+func GetenvInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid env var, using default", "key", k, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// GetenvFloat reads k as a float, falling back to def when it is unset or invalid.
+func GetenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("invalid env var, using default", "key", k, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+// GetenvDurationMs reads k as a positive number of milliseconds, falling back
+// to def when it is unset or invalid.
+func GetenvDurationMs(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		slog.Warn("invalid env var, using default", "key", k, "value", v, "default", def.String())
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
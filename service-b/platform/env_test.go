@@ -0,0 +1,36 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetenvDurationMs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 10 * time.Second},
+		{"250", 250 * time.Millisecond},
+		{"0", 10 * time.Second},
+		{"-5", 10 * time.Second},
+		{"abc", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv("UPSTREAM_TIMEOUT_MS", tt.value)
+		if got := GetenvDurationMs("UPSTREAM_TIMEOUT_MS", 10*time.Second); got != tt.want {
+			t.Errorf("UPSTREAM_TIMEOUT_MS=%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetenv(t *testing.T) {
+	t.Setenv("HTTP_ADDR", "")
+	if got := Getenv("HTTP_ADDR", ":8080"); got != ":8080" {
+		t.Errorf("unset HTTP_ADDR: got %q, want the default :8080", got)
+	}
+	t.Setenv("HTTP_ADDR", "127.0.0.1:9000")
+	if got := Getenv("HTTP_ADDR", ":8080"); got != "127.0.0.1:9000" {
+		t.Errorf("HTTP_ADDR=127.0.0.1:9000: got %q", got)
+	}
+}
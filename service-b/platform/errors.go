@@ -0,0 +1,42 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type errorResp struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WriteError writes the JSON error envelope shared by both services. message
+// is the English text; it is translated according to the request's
+// Accept-Language when a translation exists. code is never translated.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResp{Error: errorDetail{Code: code, Message: Localize(r, code, message)}})
+}
+
+// ClientCanceled reports whether err is the result of the client going
+// away. If so it marks the span, logs once at debug level and the caller
+// should return without writing a response.
+func ClientCanceled(r *http.Request, err error) bool {
+	if !errors.Is(err, context.Canceled) || r.Context().Err() == nil {
+		return false
+	}
+	trace.SpanFromContext(r.Context()).SetStatus(codes.Error, "client canceled request")
+	slog.DebugContext(r.Context(), "client canceled request", "error", err)
+	return true
+}
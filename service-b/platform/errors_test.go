@@ -0,0 +1,56 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResp {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var e errorResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("body %q is not a JSON error: %v", rec.Body, err)
+	}
+	return e
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteError(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusBadGateway, "bad_gateway", "bad gateway")
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "bad_gateway" || e.Error.Message != "bad gateway" {
+		t.Errorf("error = %+v, want bad_gateway", e.Error)
+	}
+}
+
+func TestClientCanceled(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"client gone", canceled, context.Canceled, true},
+		{"wrapped", canceled, errors.Join(errors.New("get"), context.Canceled), true},
+		{"canceled upstream, client still there", context.Background(), context.Canceled, false},
+		{"other error", canceled, context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tt.ctx)
+		if got := ClientCanceled(r, tt.err); got != tt.want {
+			t.Errorf("%s: ClientCanceled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package platform
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.8", true},
+		{"br, gzip ; q=1", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0", false},
+		{"deflate, br", false},
+		{"x-gzip", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	large := strings.Repeat(`{"city":"São Paulo"}`, 100)
+	small := `{"city":"São Paulo"}`
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		status         int
+		encoding       string
+		body           string
+		wantGzip       bool
+	}{
+		{"large", http.MethodGet, "gzip", http.StatusOK, "", large, true},
+		{"large error", http.MethodGet, "gzip", http.StatusBadGateway, "", large, true},
+		{"small", http.MethodGet, "gzip", http.StatusOK, "", small, false},
+		{"not accepted", http.MethodGet, "", http.StatusOK, "", large, false},
+		{"refused", http.MethodGet, "gzip;q=0", http.StatusOK, "", large, false},
+		{"already encoded", http.MethodGet, "gzip", http.StatusOK, "br", large, false},
+		{"head", http.MethodHead, "gzip", http.StatusOK, "", large, false},
+		{"not modified", http.MethodGet, "gzip", http.StatusNotModified, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := WithGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			body := rec.Body.String()
+			if tt.wantGzip {
+				if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", got)
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			} else if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if body != tt.body {
+				t.Errorf("body = %.40q (%d bytes), want %.40q (%d bytes)", body, len(body), tt.body, len(tt.body))
+			}
+		})
+	}
+}
+
+func TestWithGzipFlush(t *testing.T) {
+	h := WithGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: 1\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !rec.Flushed || rec.Body.String() != "data: 1\n\n" || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("flushed %v, body %q, Content-Encoding %q; want the small body flushed as is",
+			rec.Flushed, rec.Body, rec.Header().Get("Content-Encoding"))
+	}
+}
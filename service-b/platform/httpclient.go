@@ -0,0 +1,196 @@
+package platform
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var (
+	// UserAgent is sent on every outbound request; set from HTTP_USER_AGENT.
+	UserAgent = "cep_system/1.0"
+
+	// Connection pool settings for outbound requests; set from
+	// HTTP_MAX_IDLE_CONNS_PER_HOST, HTTP_IDLE_CONN_TIMEOUT_MS and
+	// HTTP_EXPECT_CONTINUE_TIMEOUT_MS.
+	MaxIdleConnsPerHost   = 10
+	IdleConnTimeout       = 90 * time.Second
+	ExpectContinueTimeout = 1 * time.Second
+
+	// MaxUpstreamBytes caps how much of an upstream response body is read;
+	// set from MAX_UPSTREAM_BYTES.
+	MaxUpstreamBytes int64 = 1 << 20
+
+	// TLSMinVersion is the oldest TLS version outbound calls accept; set
+	// from HTTP_TLS_MIN_VERSION.
+	TLSMinVersion uint16 = tls.VersionTLS12
+
+	// DebugUpstream logs every outbound request at debug level, with
+	// credentials and CEPs masked; set from DEBUG_UPSTREAM.
+	DebugUpstream bool
+)
+
+// ErrUpstreamTooLarge is returned when reading past MaxUpstreamBytes of a
+// response body.
+var ErrUpstreamTooLarge = errors.New("upstream response too large")
+
+// userAgentTransport sets User-Agent on requests that don't carry one.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// limitTransport bounds the response bodies of the requests it sends to
+// MaxUpstreamBytes.
+type limitTransport struct {
+	base http.RoundTripper
+}
+
+func (t limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// Read one byte past the limit to tell a body of exactly
+	// MaxUpstreamBytes from a longer one.
+	resp.Body = &limitedBody{ReadCloser: resp.Body, r: io.LimitReader(resp.Body, MaxUpstreamBytes+1)}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	r    io.Reader
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > MaxUpstreamBytes {
+		return n - int(b.read-MaxUpstreamBytes), ErrUpstreamTooLarge
+	}
+	return n, err
+}
+
+// debugTransport logs the masked URL, status and duration of the requests it
+// sends.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	attrs := []any{
+		"method", req.Method,
+		"url", maskURL(req.URL),
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		slog.DebugContext(req.Context(), "upstream request failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+	slog.DebugContext(req.Context(), "upstream request", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
+var (
+	// secretParams are the query parameters upstreams take credentials in.
+	secretParams = []string{"key", "appid"}
+
+	cepPattern = regexp.MustCompile(`\d{5}-?\d{3}`)
+)
+
+// maskURL returns u as a string with credentials and CEPs replaced, so it can
+// be logged.
+func maskURL(u *url.URL) string {
+	m := *u
+	q := m.Query()
+	for _, k := range secretParams {
+		if q.Has(k) {
+			q.Set(k, "xxxxx")
+		}
+	}
+	m.RawQuery = q.Encode()
+	m.Path = cepPattern.ReplaceAllString(m.Path, "xxxxxxxx")
+	m.RawPath = ""
+	m.RawQuery = cepPattern.ReplaceAllString(m.RawQuery, "xxxxxxxx")
+	return m.String()
+}
+
+// newTransport returns a copy of http.DefaultTransport with the pool
+// settings applied.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+	t.IdleConnTimeout = IdleConnTimeout
+	t.ExpectContinueTimeout = ExpectContinueTimeout
+	t.TLSClientConfig = &tls.Config{MinVersion: TLSMinVersion}
+	return t
+}
+
+// parseTLSVersion reads a HTTP_TLS_MIN_VERSION value, "1.2" or "1.3".
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q (want 1.2 or 1.3)", v)
+}
+
+// NewHTTPClient returns a traced client for outbound calls. It is built once
+// at startup and shared so connections are reused across requests.
+func NewHTTPClient() *http.Client {
+	var rt http.RoundTripper = newTransport()
+	if DebugUpstream {
+		rt = debugTransport{rt}
+	}
+	return NewHTTPClientWith(rt)
+}
+
+// NewHTTPClientWith returns a client like NewHTTPClient's whose requests are
+// sent by rt.
+func NewHTTPClientWith(rt http.RoundTripper) *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(userAgentTransport{limitTransport{rt}})}
+}
+
+// WithUserAgent returns rt setting User-Agent on requests that don't carry
+// one, without the tracing and body limit of NewHTTPClient.
+func WithUserAgent(rt http.RoundTripper) http.RoundTripper {
+	return userAgentTransport{rt}
+}
+
+// ConfigureHTTPClient reads the outbound client settings from the
+// environment. An invalid HTTP_TLS_MIN_VERSION is fatal.
+func ConfigureHTTPClient() {
+	UserAgent = Getenv("HTTP_USER_AGENT", UserAgent)
+	MaxIdleConnsPerHost = max(GetenvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", MaxIdleConnsPerHost), 1)
+	IdleConnTimeout = GetenvDurationMs("HTTP_IDLE_CONN_TIMEOUT_MS", IdleConnTimeout)
+	ExpectContinueTimeout = GetenvDurationMs("HTTP_EXPECT_CONTINUE_TIMEOUT_MS", ExpectContinueTimeout)
+	MaxUpstreamBytes = int64(max(GetenvInt("MAX_UPSTREAM_BYTES", int(MaxUpstreamBytes)), 1))
+	DebugUpstream = os.Getenv("DEBUG_UPSTREAM") == "true"
+	if v := os.Getenv("HTTP_TLS_MIN_VERSION"); v != "" {
+		var err error
+		if TLSMinVersion, err = parseTLSVersion(v); err != nil {
+			Fatal("invalid HTTP_TLS_MIN_VERSION", "error", err)
+		}
+	}
+}
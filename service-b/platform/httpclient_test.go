@@ -0,0 +1,273 @@
+package platform
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserAgentTransport(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+	}))
+	defer srv.Close()
+
+	prev := UserAgent
+	UserAgent = "cep_system/test"
+	t.Cleanup(func() { UserAgent = prev })
+	client := NewHTTPClient()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Header.Get("User-Agent") != "" {
+		t.Error("transport modified the caller's request")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "custom/1.0")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(got) != 2 || got[0] != "cep_system/test" || got[1] != "custom/1.0" {
+		t.Errorf("User-Agent = %q, want [cep_system/test custom/1.0]", got)
+	}
+}
+
+func TestNewTransportPoolSettings(t *testing.T) {
+	prevIdle, prevTimeout, prevContinue := MaxIdleConnsPerHost, IdleConnTimeout, ExpectContinueTimeout
+	MaxIdleConnsPerHost, IdleConnTimeout, ExpectContinueTimeout = 32, 45*time.Second, 2*time.Second
+	t.Cleanup(func() {
+		MaxIdleConnsPerHost, IdleConnTimeout, ExpectContinueTimeout = prevIdle, prevTimeout, prevContinue
+	})
+
+	tr := newTransport()
+	if tr.MaxIdleConnsPerHost != 32 || tr.IdleConnTimeout != 45*time.Second || tr.ExpectContinueTimeout != 2*time.Second {
+		t.Errorf("transport = %d idle per host, %v idle timeout, %v expect continue; want 32, 45s, 2s",
+			tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.ExpectContinueTimeout)
+	}
+	if tr == http.DefaultTransport {
+		t.Error("newTransport returned http.DefaultTransport itself")
+	}
+}
+
+func TestHTTPClientReusesConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := NewHTTPClient()
+
+	reused := 0
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused {
+			reused++
+		}
+	}}
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if reused != 4 {
+		t.Errorf("%d of 5 requests reused a connection, want 4", reused)
+	}
+}
+
+func TestLimitTransport(t *testing.T) {
+	prev := MaxUpstreamBytes
+	MaxUpstreamBytes = 16
+	t.Cleanup(func() { MaxUpstreamBytes = prev })
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"under the limit", 15, nil},
+		{"exactly the limit", 16, nil},
+		{"one byte over", 17, ErrUpstreamTooLarge},
+		{"far over", 4096, ErrUpstreamTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(strings.Repeat("x", tt.size)))
+			}))
+			defer srv.Close()
+
+			resp, err := NewHTTPClient().Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll error = %v, want %v", err, tt.wantErr)
+			}
+			if want := min(tt.size, 16); len(body) != want {
+				t.Errorf("read %d bytes, want %d", len(body), want)
+			}
+		})
+	}
+}
+
+func TestMaskURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://api.weatherapi.com/v1/current.json?key=s3cret&q=S%C3%A3o+Paulo", "https://api.weatherapi.com/v1/current.json?key=xxxxx&q=S%C3%A3o+Paulo"},
+		{"https://api.openweathermap.org/data/2.5/weather?appid=s3cret&lat=-23.5", "https://api.openweathermap.org/data/2.5/weather?appid=xxxxx&lat=-23.5"},
+		{"https://viacep.com.br/ws/01001000/json/", "https://viacep.com.br/ws/xxxxxxxx/json/"},
+		{"https://brasilapi.com.br/api/cep/v2/01001-000", "https://brasilapi.com.br/api/cep/v2/xxxxxxxx"},
+		{"http://service-b:8080/weather?cep=01001000&days=3", "http://service-b:8080/weather?cep=xxxxxxxx&days=3"},
+		{"http://service-b:8080/healthz", "http://service-b:8080/healthz"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := maskURL(u); got != tt.want {
+			t.Errorf("maskURL(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+		if u.String() != tt.in {
+			t.Errorf("maskURL modified its argument to %s", u)
+		}
+	}
+}
+
+func TestDebugUpstreamLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	for _, enabled := range []bool{false, true} {
+		buf := captureLogs(t)
+		prev := DebugUpstream
+		DebugUpstream = enabled
+		client := NewHTTPClient()
+		DebugUpstream = prev
+
+		resp, err := client.Get(srv.URL + "/ws/01001000/json/?key=s3cret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		var logged map[string]any
+		for _, r := range logRecords(t, buf) {
+			if r["msg"] == "upstream request" {
+				logged = r
+			}
+		}
+		if (logged != nil) != enabled {
+			t.Fatalf("DEBUG_UPSTREAM=%v: logged %v", enabled, logged)
+		}
+		if !enabled {
+			continue
+		}
+		if want := srv.URL + "/ws/xxxxxxxx/json/?key=xxxxx"; logged["url"] != want {
+			t.Errorf("logged url = %v, want %s", logged["url"], want)
+		}
+		if logged["method"] != http.MethodGet || logged["status"] != float64(http.StatusTeapot) {
+			t.Errorf("logged %v, want method GET and status 418", logged)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.1", 0, true},
+		{"1.0", 0, true},
+		{"TLS1.3", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %x, %v; want %x, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewTransportTLSMinVersion(t *testing.T) {
+	// The server speaks at most TLS 1.2.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	prev := TLSMinVersion
+	t.Cleanup(func() { TLSMinVersion = prev })
+	for _, tt := range []struct {
+		min     uint16
+		wantErr bool
+	}{
+		{tls.VersionTLS12, false},
+		{tls.VersionTLS13, true},
+	} {
+		TLSMinVersion = tt.min
+		tr := newTransport()
+		if tr.TLSClientConfig.MinVersion != tt.min {
+			t.Errorf("MinVersion = %x, want %x", tr.TLSClientConfig.MinVersion, tt.min)
+		}
+		tr.TLSClientConfig.RootCAs = roots
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("minimum %s against a TLS 1.2 server: error %v, want error %v", tls.VersionName(tt.min), err, tt.wantErr)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func BenchmarkHTTPClient(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"city":"São Paulo"}`))
+	}))
+	defer srv.Close()
+	client := NewHTTPClient()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				b.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package platform
+
+import (
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultLanguage = "en"
+
+// messages holds translated error messages keyed by language and error code.
+// English messages are the ones passed to WriteError, so only other languages
+// need entries here. Each service adds its own with AddMessages.
+var messages = map[string]map[string]string{}
+
+// AddMessages adds catalog, translated messages keyed by language and error
+// code, to the ones WriteError uses. It is meant to be called from init; a
+// code already present is overwritten.
+func AddMessages(catalog map[string]map[string]string) {
+	for lang, msgs := range catalog {
+		if messages[lang] == nil {
+			messages[lang] = make(map[string]string, len(msgs))
+		}
+		maps.Copy(messages[lang], msgs)
+	}
+}
+
+// preferredLanguage picks the supported language with the highest quality
+// value in an Accept-Language header, defaulting to English.
+func preferredLanguage(acceptLanguage string) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		var lang string
+		switch tag = strings.ToLower(tag); {
+		case tag == "pt" || strings.HasPrefix(tag, "pt-"):
+			lang = "pt-BR"
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			lang = "en"
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Localize returns the message for code in the request's preferred language,
+// or message itself when there is no translation.
+func Localize(r *http.Request, code, message string) string {
+	if m, ok := messages[preferredLanguage(r.Header.Get("Accept-Language"))][code]; ok {
+		return m
+	}
+	return message
+}
@@ -0,0 +1,75 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct{ header, want string }{
+		{"", "en"},
+		{"pt-BR", "pt-BR"},
+		{"pt", "pt-BR"},
+		{"PT-pt", "pt-BR"},
+		{"fr-FR, de", "en"},
+		{"en-US,en;q=0.9,pt-BR;q=0.8", "en"},
+		{"en;q=0.5, pt-BR;q=0.8", "pt-BR"},
+		{"fr, pt-BR;q=0.7", "pt-BR"},
+	}
+	for _, tt := range tests {
+		if got := preferredLanguage(tt.header); got != tt.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// withMessages replaces the message catalog for the duration of the test.
+func withMessages(t *testing.T) {
+	t.Helper()
+	prev := messages
+	messages = map[string]map[string]string{}
+	t.Cleanup(func() { messages = prev })
+}
+
+func TestWriteErrorLocalized(t *testing.T) {
+	withMessages(t)
+	AddMessages(map[string]map[string]string{"pt-BR": {"invalid_zipcode": "CEP inválido"}})
+
+	tests := []struct {
+		acceptLanguage, code, wantMessage string
+	}{
+		{"pt-BR", "invalid_zipcode", "CEP inválido"},
+		{"en", "invalid_zipcode", "invalid zipcode"},
+		{"pt-BR", "untranslated_code", "invalid zipcode"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		rec := httptest.NewRecorder()
+		WriteError(rec, req, http.StatusUnprocessableEntity, tt.code, "invalid zipcode")
+
+		e := decodeError(t, rec)
+		if e.Error.Code != tt.code || e.Error.Message != tt.wantMessage {
+			t.Errorf("Accept-Language %s, code %s: got %+v, want message %q and the code untranslated",
+				tt.acceptLanguage, tt.code, e.Error, tt.wantMessage)
+		}
+	}
+}
+
+func TestAddMessagesMerges(t *testing.T) {
+	withMessages(t)
+	AddMessages(map[string]map[string]string{"pt-BR": {"bad_gateway": "erro ao consultar serviço externo"}})
+	AddMessages(map[string]map[string]string{"pt-BR": {"too_many_ceps": "CEPs demais na requisição"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	for code, want := range map[string]string{
+		"bad_gateway":   "erro ao consultar serviço externo",
+		"too_many_ceps": "CEPs demais na requisição",
+	} {
+		if got := Localize(req, code, "english"); got != want {
+			t.Errorf("Localize(%s) = %q, want %q", code, got, want)
+		}
+	}
+}
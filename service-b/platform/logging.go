@@ -0,0 +1,149 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetupLogger installs a JSON slog logger as the default, at the level named
+// by LOG_LEVEL (debug, info, warn or error; info when unset or unknown).
+func SetupLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(os.Getenv("LOG_LEVEL")))); err != nil {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(NewLogHandler(os.Stdout, level)))
+}
+
+// NewLogHandler returns the JSON handler SetupLogger installs, writing to w
+// at level and above.
+func NewLogHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return traceHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}
+}
+
+// traceHandler adds trace_id and span_id to records logged with a context
+// that carries a valid span, and request_id when one is set.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// statusClientClosedRequest is logged for requests the client abandoned
+// before a response was written.
+const statusClientClosedRequest = 499
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// LogRequests logs the start and end of each request. It must run inside the
+// otelhttp handler so the span is available for trace correlation.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		slog.DebugContext(r.Context(), "request started",
+			"method", r.Method, "path", r.URL.Path)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+			if r.Context().Err() != nil {
+				// Nothing was written because the client went away; log
+				// it with nginx's "client closed request" status.
+				rec.status = statusClientClosedRequest
+			}
+		}
+
+		slog.InfoContext(r.Context(), "request finished",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// RecoverPanics turns a panic in next into a 500 JSON error, logging it with
+// the stack and marking the request's span as failed, so one bad code path
+// cannot drop the connection. If next had already started the response it is
+// left as is. http.ErrAbortHandler is re-raised, as net/http expects.
+func RecoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(fmt.Errorf("panic: %v", v))
+			span.SetStatus(codes.Error, "handler panicked")
+			slog.ErrorContext(r.Context(), "handler panicked",
+				"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.status == 0 {
+				WriteError(rec, r, http.StatusInternalServerError, "internal_error", "internal server error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
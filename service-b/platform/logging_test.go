@@ -0,0 +1,150 @@
+package platform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// captureLogs sends the default logger's JSON records, through traceHandler,
+// to the returned buffer for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(traceHandler{slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// logRecords decodes the JSON records in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestTraceHandlerAddsTraceIDs(t *testing.T) {
+	buf := captureLogs(t)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	slog.InfoContext(trace.ContextWithSpanContext(context.Background(), sc), "traced")
+	slog.InfoContext(context.Background(), "untraced")
+
+	records := logRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if records[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || records[0]["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("traced record = %v, want its trace_id and span_id", records[0])
+	}
+	if _, ok := records[1]["trace_id"]; ok {
+		t.Errorf("untraced record = %v, want no trace_id", records[1])
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	buf := captureLogs(t)
+	h := LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cep", nil))
+
+	records := logRecords(t, buf)
+	last := records[len(records)-1]
+	if last["msg"] != "request finished" || last["path"] != "/cep" || last["status"] != float64(http.StatusTeapot) {
+		t.Errorf("last record = %v, want request finished for /cep with status 418", last)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBody   string
+		wantLogged bool
+	}{
+		{
+			name:       "no panic",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			wantStatus: http.StatusOK,
+			wantBody:   "ok",
+		},
+		{
+			name:       "panic before writing",
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantStatus: http.StatusInternalServerError,
+			wantLogged: true,
+		},
+		{
+			name: "panic after writing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("partial"))
+				panic("boom")
+			},
+			wantStatus: http.StatusAccepted,
+			wantBody:   "partial",
+			wantLogged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t)
+			rec := httptest.NewRecorder()
+			RecoverPanics(tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cep", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusInternalServerError {
+				if e := decodeError(t, rec); e.Error.Code != "internal_error" {
+					t.Errorf("code = %q, want internal_error", e.Error.Code)
+				}
+			} else if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+
+			logged := false
+			for _, r := range logRecords(t, buf) {
+				if r["msg"] == "handler panicked" && r["panic"] == "boom" && r["stack"] != "" {
+					logged = true
+				}
+			}
+			if logged != tt.wantLogged {
+				t.Errorf("panic logged = %v, want %v", logged, tt.wantLogged)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicsRepanicsAbort(t *testing.T) {
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	h := RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cep", nil))
+}
@@ -0,0 +1,130 @@
+package platform
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Metrics counts the requests a service serves, in Prometheus and over OTLP,
+// and times its outbound calls.
+type Metrics struct {
+	requestsTotal    *prometheus.CounterVec
+	upstreamDuration *prometheus.HistogramVec
+
+	// otelRequests and otelRequestDuration mirror requestsTotal over OTLP;
+	// they are no-ops until CreateInstruments.
+	otelRequests        metric.Int64Counter
+	otelRequestDuration metric.Float64Histogram
+}
+
+// NewMetrics registers a service's Prometheus metrics with reg, labeled with
+// service so both services can share a registry when they run in one
+// process.
+func NewMetrics(reg prometheus.Registerer, service string) *Metrics {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"service": service}, reg)
+	m := &Metrics{
+		requestsTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by handler and status code.",
+		}, []string{"handler", "code"}),
+		upstreamDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Duration of outbound calls, by provider.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"provider"}),
+	}
+	m.CreateInstruments(metricnoop.NewMeterProvider().Meter(service))
+	return m
+}
+
+// SetupMeter installs the global meter provider, exporting to the same OTLP
+// endpoint as the traces, and returns a shutdown func that flushes pending
+// metrics. Like SetupTracer it honors OTEL_SDK_DISABLED and
+// OTEL_EXPORTER_OPTIONAL.
+func SetupMeter(endpoint, serviceName string) func() {
+	shutdown := func() {}
+	var mp metric.MeterProvider = metricnoop.NewMeterProvider()
+	if os.Getenv("OTEL_SDK_DISABLED") != "true" {
+		ctx, cancel := context.WithTimeout(context.Background(), exporterTimeout)
+		defer cancel()
+		exp, err := otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(endpoint),
+			otlpmetrichttp.WithInsecure(),
+		)
+		switch {
+		case err == nil:
+			sdk := sdkmetric.NewMeterProvider(
+				sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)),
+				sdkmetric.WithResource(resource.NewWithAttributes(
+					semconv.SchemaURL,
+					semconv.ServiceName(serviceName),
+				)),
+			)
+			mp = sdk
+			shutdown = func() { ShutdownWithin(exporterTimeout, sdk.Shutdown) }
+		case os.Getenv("OTEL_EXPORTER_OPTIONAL") == "true":
+			slog.Warn("OTLP metric exporter unavailable, metrics export disabled", "error", err)
+		default:
+			Fatal("failed to create metric exporter", "error", err)
+		}
+	}
+	otel.SetMeterProvider(mp)
+	return shutdown
+}
+
+// CreateInstruments creates the OTLP instruments InstrumentHandler records to
+// on meter.
+func (m *Metrics) CreateInstruments(meter metric.Meter) {
+	var err error
+	if m.otelRequests, err = meter.Int64Counter("http.server.requests",
+		metric.WithDescription("HTTP requests served, by handler and status code.")); err != nil {
+		Fatal("failed to create metric", "error", err)
+	}
+	if m.otelRequestDuration, err = meter.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("Duration of HTTP requests, by handler and status code."),
+		metric.WithUnit("s")); err != nil {
+		Fatal("failed to create metric", "error", err)
+	}
+}
+
+// InstrumentHandler counts requests served by h under the given handler
+// label, in Prometheus and over OTLP, and records their duration over OTLP.
+func (m *Metrics) InstrumentHandler(name string, h http.Handler) http.Handler {
+	counted := promhttp.InstrumentHandlerCounter(
+		m.requestsTotal.MustCurryWith(prometheus.Labels{"handler": name}), h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		counted.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := metric.WithAttributes(
+			attribute.String("handler", name),
+			attribute.Int("http.response.status_code", rec.status),
+		)
+		m.otelRequests.Add(r.Context(), 1, attrs)
+		m.otelRequestDuration.Record(r.Context(), time.Since(start).Seconds(), attrs)
+	})
+}
+
+// ObserveUpstream records the time elapsed since start for provider. It is
+// meant to be deferred: defer metrics.ObserveUpstream("service-b", time.Now()).
+func (m *Metrics) ObserveUpstream(provider string, start time.Time) {
+	m.upstreamDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+}
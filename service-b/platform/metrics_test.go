@@ -0,0 +1,94 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestInstrumentHandlerCountsRequests(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry(), "test")
+	h := m.InstrumentHandler("test_count", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	for _, target := range []string{"/", "/", "/?fail=1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues("test_count", "200")); got != 2 {
+		t.Errorf("requests with code 200 = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues("test_count", "502")); got != 1 {
+		t.Errorf("requests with code 502 = %v, want 1", got)
+	}
+}
+
+func TestNewMetricsServiceLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, b := NewMetrics(reg, "service-a"), NewMetrics(reg, "service-b")
+	a.ObserveUpstream("service-b", time.Now())
+	b.ObserveUpstream("viacep", time.Now())
+
+	if n, err := testutil.GatherAndCount(reg, "upstream_request_duration_seconds"); err != nil || n != 2 {
+		t.Errorf("upstream series = %d (%v), want one per service", n, err)
+	}
+}
+
+func TestObserveUpstream(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry(), "test")
+	m.ObserveUpstream("test-provider", time.Now())
+	if got := testutil.CollectAndCount(m.upstreamDuration, "upstream_request_duration_seconds"); got != 1 {
+		t.Errorf("upstream series = %d, want 1", got)
+	}
+}
+
+func TestInstrumentHandlerRecordsOTelMetrics(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry(), "test")
+	reader := sdkmetric.NewManualReader()
+	m.CreateInstruments(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+
+	h := m.InstrumentHandler("test_otel", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	want := attribute.NewSet(attribute.String("handler", "test_otel"), attribute.Int("http.response.status_code", http.StatusNotFound))
+	var counted, timed uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, md := range sm.Metrics {
+			switch data := md.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					if md.Name == "http.server.requests" && dp.Attributes.Equals(&want) {
+						counted += uint64(dp.Value)
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					if md.Name == "http.server.request.duration" && dp.Attributes.Equals(&want) {
+						timed += dp.Count
+					}
+				}
+			}
+		}
+	}
+	if counted != 2 || timed != 2 {
+		t.Errorf("recorded %d requests and %d durations for test_otel 404, want 2 and 2", counted, timed)
+	}
+}
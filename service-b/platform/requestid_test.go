@@ -0,0 +1,46 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		inbound  string
+		generate bool
+	}{
+		{"echoes the inbound ID", "abc-123", false},
+		{"generates a UUID", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inContext string
+			h := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inContext = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.inbound != "" {
+				req.Header.Set(RequestIDHeader, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if tt.generate {
+				if _, err := uuid.Parse(got); err != nil {
+					t.Errorf("%s = %q, want a UUID", RequestIDHeader, got)
+				}
+			} else if got != tt.inbound {
+				t.Errorf("%s = %q, want %q", RequestIDHeader, got, tt.inbound)
+			}
+			if inContext != got {
+				t.Errorf("context ID = %q, want the echoed %q", inContext, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Package platform holds the HTTP server and client, logging, tracing, metrics
+// and configuration plumbing shared by service-a and service-b.
+package platform
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server timeouts, against slow or idle clients holding connections open;
+// set from HTTP_READ_HEADER_TIMEOUT_MS, HTTP_READ_TIMEOUT_MS,
+// HTTP_WRITE_TIMEOUT_MS and HTTP_IDLE_TIMEOUT_MS. Handlers that stream for
+// longer lift the deadlines on their own connection.
+var (
+	ReadHeaderTimeout = 5 * time.Second
+	ReadTimeout       = 30 * time.Second
+	WriteTimeout      = 30 * time.Second
+	IdleTimeout       = 120 * time.Second
+)
+
+// ShutdownGracePeriod bounds how long in-flight requests may run after a
+// shutdown signal.
+const ShutdownGracePeriod = 10 * time.Second
+
+// ConfigureServer reads the server timeouts from the environment.
+func ConfigureServer() {
+	ReadHeaderTimeout = GetenvDurationMs("HTTP_READ_HEADER_TIMEOUT_MS", ReadHeaderTimeout)
+	ReadTimeout = GetenvDurationMs("HTTP_READ_TIMEOUT_MS", ReadTimeout)
+	WriteTimeout = GetenvDurationMs("HTTP_WRITE_TIMEOUT_MS", WriteTimeout)
+	IdleTimeout = GetenvDurationMs("HTTP_IDLE_TIMEOUT_MS", IdleTimeout)
+}
+
+// NewServer returns a server for handler on addr with the timeouts applied.
+func NewServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+	}
+}
+
+// Serve runs srv until ctx is done, then shuts it down gracefully, giving
+// in-flight requests up to ShutdownGracePeriod to complete. It serves HTTPS
+// when certFile and keyFile are set and plain HTTP otherwise. name is the
+// service reported in the startup log.
+func Serve(ctx context.Context, name string, srv *http.Server, certFile, keyFile string) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	useTLS := certFile != "" && keyFile != ""
+	slog.Info(name+" listening", "addr", ln.Addr().String(), "tls", useTLS)
+
+	errCh := make(chan error, 1)
+	go func() {
+		if useTLS {
+			errCh <- srv.ServeTLS(ln, certFile, keyFile)
+			return
+		}
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownGracePeriod)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,287 @@
+package platform
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withServerTimeouts sets the server timeouts for the duration of the test.
+func withServerTimeouts(t *testing.T, readHeader, read, write, idle time.Duration) {
+	t.Helper()
+	prev := [4]time.Duration{ReadHeaderTimeout, ReadTimeout, WriteTimeout, IdleTimeout}
+	ReadHeaderTimeout, ReadTimeout, WriteTimeout, IdleTimeout = readHeader, read, write, idle
+	t.Cleanup(func() {
+		ReadHeaderTimeout, ReadTimeout, WriteTimeout, IdleTimeout = prev[0], prev[1], prev[2], prev[3]
+	})
+}
+
+// startServer serves handler with NewServer on a loopback port and returns
+// its address.
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(ln.Addr().String(), handler)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	withServerTimeouts(t, time.Second, 2*time.Second, 3*time.Second, 4*time.Second)
+
+	srv := NewServer(":8080", http.NotFoundHandler())
+	if srv.Addr != ":8080" || srv.Handler == nil {
+		t.Errorf("server = %s %v, want :8080 with the handler", srv.Addr, srv.Handler)
+	}
+	got := [4]time.Duration{srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout}
+	if want := [4]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second}; got != want {
+		t.Errorf("timeouts (read header, read, write, idle) = %v, want %v", got, want)
+	}
+}
+
+func TestNewServerReadHeaderTimeout(t *testing.T) {
+	withServerTimeouts(t, 100*time.Millisecond, time.Minute, time.Minute, time.Minute)
+	var calls atomic.Int32
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+
+	// A slowloris client sends part of the headers and then stalls.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("server kept the stalled connection open past the read header timeout")
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("handler called %d times for an incomplete request", n)
+	}
+
+	// A client sending its headers in time is served as usual.
+	resp, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("status = %d with %d handler calls, want 200 and 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestNewServerIdleTimeout(t *testing.T) {
+	withServerTimeouts(t, time.Minute, time.Minute, time.Minute, 100*time.Millisecond)
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The kept-alive connection is closed once it sits idle.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Errorf("read on idle connection = %v, want EOF", err)
+	}
+}
+
+// freeAddr returns a loopback address with a port that was free a moment ago.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	addr := freeAddr(t)
+	srv := NewServer(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, "test", srv, "", "") }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		got <- result{string(b), err}
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if r := <-got; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request = %q, %v; want done", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v, want nil after a graceful shutdown", err)
+	}
+	if _, err := http.Get("http://" + addr); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestServeListensOnAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The address is taken, so serve must fail instead of serving elsewhere.
+	if err := Serve(context.Background(), "test", NewServer(ln.Addr().String(), http.NotFoundHandler()), "", ""); err == nil {
+		t.Fatal("serve on a busy address succeeded")
+	}
+}
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to a
+// temporary directory, returning their paths and a pool trusting it.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t)
+	addr := freeAddr(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, "test", NewServer(addr, mux), certFile, keyFile) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer client.CloseIdleConnections()
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://" + addr + "/healthz"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("GET /healthz over HTTPS = %d, TLS %v; want 200 over TLS", resp.StatusCode, resp.TLS != nil)
+	}
+
+	// Plain HTTP is refused on the TLS port.
+	if resp, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /healthz over HTTP = %d, want 400", resp.StatusCode)
+		}
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("serve returned %v after shutdown", err)
+	}
+}
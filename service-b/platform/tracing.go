@@ -0,0 +1,94 @@
+package platform
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// exporterTimeout bounds creating the OTLP exporters and flushing them at
+// shutdown, so an unreachable collector cannot hang startup or exit.
+const exporterTimeout = 5 * time.Second
+
+// SetupTracer installs the global tracer provider and propagator and returns
+// a func that flushes pending spans. With OTEL_SDK_DISABLED=true it installs
+// a no-op provider and never creates the OTLP exporter; with
+// OTEL_EXPORTER_OPTIONAL=true it falls back to one if the exporter fails.
+func SetupTracer(endpoint, serviceName string) func() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exporterTimeout)
+	defer cancel()
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithInsecure(),
+		// Failed exports are retried with exponential backoff; batches that
+		// still fail after a minute are dropped.
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Second,
+			MaxInterval:     15 * time.Second,
+			MaxElapsedTime:  time.Minute,
+		}),
+	)
+	if err != nil {
+		if os.Getenv("OTEL_EXPORTER_OPTIONAL") != "true" {
+			Fatal("failed to create exporter", "error", err)
+		}
+		slog.Warn("OTLP trace exporter unavailable, tracing disabled", "error", err)
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func() {}
+	}
+	rsrc := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	)
+	tp := trace.NewTracerProvider(
+		// Spans are queued while the collector is unreachable; once the queue
+		// is full new spans are dropped rather than held in memory.
+		trace.WithBatcher(exp,
+			trace.WithMaxQueueSize(max(GetenvInt("OTEL_BSP_MAX_QUEUE_SIZE", 2048), 1)),
+			trace.WithMaxExportBatchSize(max(GetenvInt("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", 512), 1)),
+		),
+		trace.WithResource(rsrc),
+		trace.WithSampler(traceSampler()),
+	)
+	otel.SetTracerProvider(tp)
+	return func() { ShutdownWithin(exporterTimeout, tp.Shutdown) }
+}
+
+// traceSampler samples every trace unless OTEL_TRACES_SAMPLER_ARG sets the
+// ratio of root traces to keep; child spans follow their parent's decision.
+func traceSampler() trace.Sampler {
+	v := os.Getenv("OTEL_TRACES_SAMPLER_ARG")
+	if v == "" {
+		return trace.AlwaysSample()
+	}
+	ratio, err := strconv.ParseFloat(v, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		slog.Warn("invalid OTEL_TRACES_SAMPLER_ARG, sampling every trace", "value", v)
+		return trace.AlwaysSample()
+	}
+	return trace.ParentBased(trace.TraceIDRatioBased(ratio))
+}
+
+// ShutdownWithin calls shutdown with a context that expires after d.
+func ShutdownWithin(d time.Duration, shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	_ = shutdown(ctx)
+}
@@ -0,0 +1,166 @@
+package platform
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// withGlobalProviders restores the global OTel providers and propagator
+// after the test.
+func withGlobalProviders(t *testing.T) {
+	t.Helper()
+	tp, mp, prop := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+		otel.SetTextMapPropagator(prop)
+	})
+}
+
+func TestSetupTracerDisabled(t *testing.T) {
+	withGlobalProviders(t)
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+
+	// No collector listens there; a disabled SDK must not try to reach it.
+	shutdown := SetupTracer("http://127.0.0.1:1", "test")
+	defer shutdown()
+
+	if _, ok := otel.GetTracerProvider().(noop.TracerProvider); !ok {
+		t.Errorf("tracer provider = %T, want the no-op provider", otel.GetTracerProvider())
+	}
+	if fields := otel.GetTextMapPropagator().Fields(); len(fields) == 0 || fields[0] != "traceparent" {
+		t.Errorf("propagator fields = %v, want traceparent: inbound context is still propagated", fields)
+	}
+}
+
+func TestSetupTracerDeadCollector(t *testing.T) {
+	withGlobalProviders(t)
+	t.Setenv("OTEL_EXPORTER_OPTIONAL", "true")
+
+	// Nothing listens there; startup and an idle shutdown must not wait on it.
+	start := time.Now()
+	shutdown := SetupTracer("http://127.0.0.1:1", "test")
+	shutdownMeter := SetupMeter("http://127.0.0.1:1", "test")
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Errorf("tracer provider = %T, want the SDK provider", otel.GetTracerProvider())
+	}
+	shutdownMeter()
+	shutdown()
+	if elapsed := time.Since(start); elapsed >= exporterTimeout {
+		t.Errorf("setup and shutdown took %v, want less than %v", elapsed, exporterTimeout)
+	}
+}
+
+func TestShutdownWithin(t *testing.T) {
+	start := time.Now()
+	ShutdownWithin(20*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("ShutdownWithin returned after %v, want about 20ms", elapsed)
+	}
+}
+
+func TestTraceSampler(t *testing.T) {
+	sampledParent := oteltrace.ContextWithSpanContext(context.Background(), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+	}))
+	tests := []struct {
+		arg              string
+		wantDescription  string
+		wantRootSampled  bool
+		wantChildSampled bool
+	}{
+		{"", "AlwaysOnSampler", true, true},
+		{"1", "ParentBased{root:AlwaysOnSampler", true, true},
+		{"0", "ParentBased{root:TraceIDRatioBased{0}", false, true},
+		{"0.25", "ParentBased{root:TraceIDRatioBased{0.25}", false, true},
+		{"1.5", "AlwaysOnSampler", true, true},
+		{"-1", "AlwaysOnSampler", true, true},
+		{"half", "AlwaysOnSampler", true, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.arg)
+		s := traceSampler()
+		if !strings.HasPrefix(s.Description(), tt.wantDescription) {
+			t.Errorf("OTEL_TRACES_SAMPLER_ARG=%q: sampler %s, want %s", tt.arg, s.Description(), tt.wantDescription)
+		}
+
+		// A trace ID of all ones is past every ratio below 1.
+		root := s.ShouldSample(sdktrace.SamplingParameters{
+			ParentContext: context.Background(),
+			TraceID:       oteltrace.TraceID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			Name:          "root",
+		})
+		if got := root.Decision == sdktrace.RecordAndSample; got != tt.wantRootSampled {
+			t.Errorf("OTEL_TRACES_SAMPLER_ARG=%q: root sampled = %v, want %v", tt.arg, got, tt.wantRootSampled)
+		}
+		child := s.ShouldSample(sdktrace.SamplingParameters{ParentContext: sampledParent, TraceID: oteltrace.TraceID{1}, Name: "child"})
+		if got := child.Decision == sdktrace.RecordAndSample; got != tt.wantChildSampled {
+			t.Errorf("OTEL_TRACES_SAMPLER_ARG=%q: child of a sampled parent sampled = %v, want %v", tt.arg, got, tt.wantChildSampled)
+		}
+	}
+}
+
+// withCollector serves an OTLP/HTTP trace endpoint that answers the nth
+// export with status(n), counting from 1, and returns the export count.
+func withCollector(t *testing.T, status func(n int32) int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var exports atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status(exports.Add(1)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &exports
+}
+
+func TestSetupTracerExportBatchSize(t *testing.T) {
+	withGlobalProviders(t)
+	srv, exports := withCollector(t, func(int32) int { return http.StatusOK })
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "2")
+
+	shutdown := SetupTracer(srv.URL, "test")
+	for i := 0; i < 5; i++ {
+		_, span := otel.Tracer("test").Start(context.Background(), "span")
+		span.End()
+	}
+	shutdown()
+
+	if n := exports.Load(); n < 3 {
+		t.Errorf("5 spans went out in %d exports, want at least 3 with a batch size of 2", n)
+	}
+}
+
+func TestSetupTracerRetriesFailedExports(t *testing.T) {
+	withGlobalProviders(t)
+	srv, exports := withCollector(t, func(n int32) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	shutdown := SetupTracer(srv.URL, "test")
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	shutdown()
+
+	if n := exports.Load(); n != 2 {
+		t.Errorf("collector got %d exports, want the failed one and its retry", n)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cepCacheSweepInterval is how often expired CEP cache entries are dropped.
+const cepCacheSweepInterval = time.Minute
+
+type locationCacheEntry struct {
+	cep     string
+	loc     location
+	expires time.Time
+
+	// refreshAt is when the entry becomes due for a background refresh,
+	// a random point within refreshWindow of expires; refreshing is set
+	// while one is in flight.
+	refreshAt  time.Time
+	refreshing bool
+}
+
+// locationCache keeps resolved CEP-to-location lookups in memory for ttl,
+// up to maxEntries of them; the least recently used entry is evicted first.
+// A ttl <= 0 disables caching. Entries requested within refreshWindow of
+// expiring are still served but flagged for refresh, so hot CEPs never miss;
+// the point within the window is jittered per entry so entries cached
+// together are not all refreshed together.
+type locationCache struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	refreshWindow time.Duration
+	maxEntries    int
+	clock         clock
+	order         *list.List // of *locationCacheEntry, most recently used first
+	entries       map[string]*list.Element
+}
+
+func newLocationCache(ttl, refreshWindow time.Duration, maxEntries int) *locationCache {
+	return &locationCache{
+		ttl:           ttl,
+		refreshWindow: min(refreshWindow, ttl),
+		maxEntries:    max(maxEntries, 1),
+		clock:         realClock{},
+		order:         list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached location for cep. refresh is true for the single
+// caller that should refresh an entry due for it, and that caller must end
+// with Set or RefreshFailed; meanwhile the entry keeps being served.
+func (c *locationCache) Get(cep string) (loc location, ok, refresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[cep]
+	if !ok {
+		return location{}, false, false
+	}
+	e := el.Value.(*locationCacheEntry)
+	now := c.clock.Now()
+	if now.After(e.expires) {
+		c.remove(el)
+		return location{}, false, false
+	}
+	c.order.MoveToFront(el)
+	if c.refreshWindow > 0 && !e.refreshing && !now.Before(e.refreshAt) {
+		e.refreshing = true
+		refresh = true
+	}
+	return e.loc, true, refresh
+}
+
+func (c *locationCache) Set(cep string, loc location) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.clock.Now().Add(c.ttl)
+	e := &locationCacheEntry{cep: cep, loc: loc, expires: expires, refreshAt: expires}
+	if c.refreshWindow > 0 {
+		e.refreshAt = expires.Add(-jitter(c.refreshWindow))
+	}
+	if el, ok := c.entries[cep]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[cep] = c.order.PushFront(e)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// RefreshFailed lets a later Get retry the refresh of cep.
+func (c *locationCache) RefreshFailed(cep string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[cep]; ok {
+		el.Value.(*locationCacheEntry).refreshing = false
+	}
+}
+
+// sweep drops the expired entries. Get only drops an expired entry when its
+// CEP is looked up again, so without it CEPs seen once stay until evicted.
+func (c *locationCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if now.After(el.Value.(*locationCacheEntry).expires) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// sweepEvery runs sweep every interval for the life of the process.
+func (c *locationCache) sweepEvery(interval time.Duration) {
+	for {
+		<-c.clock.After(interval)
+		c.sweep()
+	}
+}
+
+// remove drops el; c.mu must be held.
+func (c *locationCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*locationCacheEntry).cep)
+}
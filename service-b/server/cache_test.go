@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocationCacheExpires(t *testing.T) {
+	clk := newFakeClock()
+	c := newLocationCache(time.Hour, 0, 10000)
+	c.clock = clk
+
+	c.Set("01001000", location{City: "São Paulo"})
+	clk.Advance(time.Hour)
+	if loc, ok, _ := c.Get("01001000"); !ok || loc.City != "São Paulo" {
+		t.Fatalf("Get at ttl = %+v, %v; want the cached location", loc, ok)
+	}
+	clk.Advance(time.Nanosecond)
+	if _, ok, _ := c.Get("01001000"); ok {
+		t.Fatal("Get after ttl found an expired entry")
+	}
+}
+
+func TestLocationCacheRefreshWindow(t *testing.T) {
+	withFakeTime(t)
+	clk := newFakeClock()
+	c := newLocationCache(time.Hour, 10*time.Minute, 10000)
+	c.clock = clk
+
+	// jitter picks the start of the window.
+	c.Set("01001000", location{City: "São Paulo"})
+	clk.Advance(50*time.Minute - time.Nanosecond)
+	if _, _, refresh := c.Get("01001000"); refresh {
+		t.Fatal("refresh requested before the window")
+	}
+	clk.Advance(2 * time.Nanosecond)
+	if _, ok, refresh := c.Get("01001000"); !ok || !refresh {
+		t.Fatalf("Get in window = ok %v, refresh %v; want both", ok, refresh)
+	}
+	if _, ok, refresh := c.Get("01001000"); !ok || refresh {
+		t.Fatalf("second Get in window = ok %v, refresh %v; want served without refresh", ok, refresh)
+	}
+	c.RefreshFailed("01001000")
+	if _, _, refresh := c.Get("01001000"); !refresh {
+		t.Fatal("refresh not retried after RefreshFailed")
+	}
+}
+
+func TestLocationCacheDisabled(t *testing.T) {
+	c := newLocationCache(0, 0, 10000)
+	c.Set("01001000", location{City: "São Paulo"})
+	if _, ok, _ := c.Get("01001000"); ok {
+		t.Fatal("Get found an entry with caching disabled")
+	}
+}
+
+func TestLocationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLocationCache(time.Hour, 0, 2)
+	c.Set("01001000", location{City: "São Paulo"})
+	c.Set("20040020", location{City: "Rio de Janeiro"})
+	c.Get("01001000")
+	c.Set("30130010", location{City: "Belo Horizonte"})
+
+	for cep, want := range map[string]bool{"01001000": true, "20040020": false, "30130010": true} {
+		if _, ok, _ := c.Get(cep); ok != want {
+			t.Errorf("Get(%s) found %v, want %v", cep, ok, want)
+		}
+	}
+	if n := c.order.Len(); n != 2 {
+		t.Errorf("cache holds %d entries, want 2", n)
+	}
+}
+
+func TestLocationCacheSweep(t *testing.T) {
+	clk := newFakeClock()
+	c := newLocationCache(time.Hour, 0, 10)
+	c.clock = clk
+
+	c.Set("01001000", location{City: "São Paulo"})
+	clk.Advance(30 * time.Minute)
+	c.Set("20040020", location{City: "Rio de Janeiro"})
+	clk.Advance(30*time.Minute + time.Nanosecond)
+	c.sweep()
+
+	if _, ok := c.entries["01001000"]; ok {
+		t.Error("sweep kept an expired entry")
+	}
+	if _, ok, _ := c.Get("20040020"); !ok || c.order.Len() != 1 {
+		t.Errorf("after sweep: live entry found %v, %d entries; want it alone", ok, c.order.Len())
+	}
+}
+
+func TestResolveLocationCaches(t *testing.T) {
+	var calls atomic.Int32
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+
+	for i := 0; i < 3; i++ {
+		loc, err := resolveLocation(context.Background(), "01001000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.City != "São Paulo" {
+			t.Fatalf("city = %q, want São Paulo", loc.City)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("viacep called %d times, want 1", n)
+	}
+
+	if _, err := resolveLocation(context.Background(), "20040020"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("viacep called %d times after a second CEP, want 2", n)
+	}
+}
+
+func TestResolveLocationExpiresOnFakeClock(t *testing.T) {
+	var calls atomic.Int32
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	clk := newFakeClock()
+	cepCache.clock = clk
+
+	steps := []struct {
+		advance   time.Duration
+		wantCalls int32
+	}{
+		{0, 1},
+		{59 * time.Minute, 1},
+		{time.Minute, 1},
+		{time.Nanosecond, 2},
+		{time.Hour, 2},
+		{time.Nanosecond, 3},
+	}
+	for _, s := range steps {
+		clk.Advance(s.advance)
+		if _, err := resolveLocation(context.Background(), "01001000"); err != nil {
+			t.Fatal(err)
+		}
+		if n := calls.Load(); n != s.wantCalls {
+			t.Fatalf("after %v more: viacep called %d times, want %d", s.advance, n, s.wantCalls)
+		}
+	}
+}
+
+// withRefreshingCache gives the test a location cache on a fake clock with
+// an hour's ttl and a ten-minute refresh window, allowing maxRefreshes
+// background refreshes at a time. It is meant to follow withViaCEP.
+func withRefreshingCache(t *testing.T, maxRefreshes int) *fakeClock {
+	t.Helper()
+	withFakeTime(t)
+	clk := newFakeClock()
+	prevSlots := cepRefreshSlots
+	cepCache = newLocationCache(time.Hour, 10*time.Minute, 10000)
+	cepCache.clock = clk
+	cepRefreshSlots = make(chan struct{}, maxRefreshes)
+	t.Cleanup(func() { cepRefreshSlots = prevSlots })
+	return clk
+}
+
+// waitForRefreshes waits until no background refresh holds a slot, so none
+// outlives the test. It takes every slot and gives them back, which also
+// orders the refreshes' writes before the caller's reads.
+func waitForRefreshes(t *testing.T) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for range cap(cepRefreshSlots) {
+		select {
+		case cepRefreshSlots <- struct{}{}:
+		case <-timeout:
+			t.Fatal("background refresh still running")
+		}
+	}
+	for range cap(cepRefreshSlots) {
+		<-cepRefreshSlots
+	}
+}
+
+// waitForCity polls the cache until cep resolves to city.
+func waitForCity(t *testing.T, cep, city string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if loc, ok, _ := cepCache.Get(cep); ok && loc.City == city {
+			return
+		}
+	}
+	t.Fatalf("cache never got %s for %s", city, cep)
+}
+
+func TestResolveLocationStaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	var city atomic.Value
+	city.Store("São Paulo")
+	release := make(chan struct{})
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, `{"localidade":%q,"uf":"SP"}`, city.Load())
+	}))
+	clk := withRefreshingCache(t, 1)
+
+	resolve := func() string {
+		t.Helper()
+		loc, err := resolveLocation(context.Background(), "01001000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc.City
+	}
+
+	if got := resolve(); got != "São Paulo" || calls.Load() != 1 {
+		t.Fatalf("first lookup = %q after %d calls, want São Paulo after 1", got, calls.Load())
+	}
+
+	// Outside the window the entry is served without a refresh.
+	clk.Advance(50 * time.Minute)
+	if got := resolve(); got != "São Paulo" || calls.Load() != 1 {
+		t.Fatalf("before the window = %q after %d calls, want the cached entry and no call", got, calls.Load())
+	}
+
+	// Inside it the old entry is served at once while viacep is asked again,
+	// and only once however often the CEP is requested meanwhile.
+	city.Store("São Paulo - SP")
+	clk.Advance(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := resolve(); got != "São Paulo" {
+			t.Fatalf("during refresh = %q, want the stale São Paulo", got)
+		}
+	}
+	close(release)
+	waitForCity(t, "01001000", "São Paulo - SP")
+	waitForRefreshes(t)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("viacep called %d times, want 2", n)
+	}
+
+	// The refreshed entry starts a new ttl.
+	clk.Advance(30 * time.Minute)
+	if got := resolve(); got != "São Paulo - SP" || calls.Load() != 2 {
+		t.Errorf("after refresh = %q after %d calls, want the refreshed entry and no call", got, calls.Load())
+	}
+}
+
+func TestResolveLocationRefreshesBounded(t *testing.T) {
+	var calls atomic.Int32
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	clk := withRefreshingCache(t, 1)
+
+	if _, err := resolveLocation(context.Background(), "01001000"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(55 * time.Minute)
+
+	// With every refresh slot taken the entry is served and left due.
+	cepRefreshSlots <- struct{}{}
+	if _, err := resolveLocation(context.Background(), "01001000"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("viacep called %d times with no free refresh slot, want 1", n)
+	}
+
+	<-cepRefreshSlots
+	if _, err := resolveLocation(context.Background(), "01001000"); err != nil {
+		t.Fatal(err)
+	}
+	waitForRefreshes(t)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("viacep called %d times once a slot freed up, want 2", n)
+	}
+}
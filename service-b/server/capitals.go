@@ -0,0 +1,46 @@
+package server
+
+// weatherFallbackHeader is set to "capital" when the weather is that of the
+// state capital because the provider did not know the CEP's city.
+const weatherFallbackHeader = "X-Weather-Fallback"
+
+// stateCapitals maps each UF to its capital, as weather providers know it.
+var stateCapitals = map[string]string{
+	"AC": "Rio Branco",
+	"AL": "Maceió",
+	"AM": "Manaus",
+	"AP": "Macapá",
+	"BA": "Salvador",
+	"CE": "Fortaleza",
+	"DF": "Brasília",
+	"ES": "Vitória",
+	"GO": "Goiânia",
+	"MA": "São Luís",
+	"MG": "Belo Horizonte",
+	"MS": "Campo Grande",
+	"MT": "Cuiabá",
+	"PA": "Belém",
+	"PB": "João Pessoa",
+	"PE": "Recife",
+	"PI": "Teresina",
+	"PR": "Curitiba",
+	"RJ": "Rio de Janeiro",
+	"RN": "Natal",
+	"RO": "Porto Velho",
+	"RR": "Boa Vista",
+	"RS": "Porto Alegre",
+	"SC": "Florianópolis",
+	"SE": "Aracaju",
+	"SP": "São Paulo",
+	"TO": "Palmas",
+}
+
+// capitalLocation returns the capital of loc's state as a location to query
+// the weather for, or false when there is none or loc already is it.
+func capitalLocation(loc location) (location, bool) {
+	capital, ok := stateCapitals[loc.UF]
+	if !ok || capital == loc.City {
+		return location{}, false
+	}
+	return location{City: capital, UF: loc.UF}, true
+}
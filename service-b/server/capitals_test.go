@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestCapitalLocation(t *testing.T) {
+	tests := []struct {
+		loc    location
+		want   string
+		wantOK bool
+	}{
+		{location{City: "Urupema", UF: "SC"}, "Florianópolis", true},
+		{location{City: "Xapuri", UF: "AC"}, "Rio Branco", true},
+		{location{City: "Florianópolis", UF: "SC"}, "", false},
+		{location{City: "Somewhere"}, "", false},
+		{location{City: "Somewhere", UF: "XX"}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := capitalLocation(tt.loc)
+		if ok != tt.wantOK || got.City != tt.want {
+			t.Errorf("capitalLocation(%+v) = %q, %v; want %q, %v", tt.loc, got.City, ok, tt.want, tt.wantOK)
+		}
+		if ok && got.UF != tt.loc.UF {
+			t.Errorf("capitalLocation(%+v) UF = %q, want %q", tt.loc, got.UF, tt.loc.UF)
+		}
+	}
+}
+
+func TestStateCapitalsCoverEveryUF(t *testing.T) {
+	if len(stateCapitals) != 27 {
+		t.Errorf("stateCapitals has %d entries, want the 26 states and the Federal District", len(stateCapitals))
+	}
+	for uf, capital := range stateCapitals {
+		if len(uf) != 2 || capital == "" {
+			t.Errorf("stateCapitals[%q] = %q", uf, capital)
+		}
+	}
+}
+
+func TestHandleWeatherCapitalFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		viaCEP       string
+		known        []string
+		wantStatus   int
+		wantQueries  []string
+		wantFallback bool
+	}{
+		{
+			name:         "unknown city falls back to the capital",
+			viaCEP:       `{"localidade":"Urupema","uf":"SC"}`,
+			known:        []string{"Florianópolis"},
+			wantStatus:   http.StatusOK,
+			wantQueries:  []string{"Urupema", "Florianópolis"},
+			wantFallback: true,
+		},
+		{
+			name:        "known city needs no fallback",
+			viaCEP:      `{"localidade":"Urupema","uf":"SC"}`,
+			known:       []string{"Urupema", "Florianópolis"},
+			wantStatus:  http.StatusOK,
+			wantQueries: []string{"Urupema"},
+		},
+		{
+			name:        "capital unknown too",
+			viaCEP:      `{"localidade":"Urupema","uf":"SC"}`,
+			wantStatus:  http.StatusNotFound,
+			wantQueries: []string{"Urupema", "Florianópolis"},
+		},
+		{
+			name:        "the capital itself is not retried",
+			viaCEP:      `{"localidade":"Florianópolis","uf":"SC"}`,
+			wantStatus:  http.StatusNotFound,
+			wantQueries: []string{"Florianópolis"},
+		},
+		{
+			name:        "no state to fall back to",
+			viaCEP:      `{"localidade":"Urupema"}`,
+			wantStatus:  http.StatusNotFound,
+			wantQueries: []string{"Urupema"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.viaCEP))
+			}))
+			var (
+				mu      sync.Mutex
+				queries []string
+			)
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query().Get("q")
+				mu.Lock()
+				queries = append(queries, q)
+				mu.Unlock()
+				for _, city := range tt.known {
+					if q == city {
+						w.Write([]byte(`{"current":{"temp_c":18}}`))
+						return
+					}
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"code":1006,"message":"No matching location found."}}`))
+			}))
+
+			rec := getWeather(t, "/weather?cep=88625000")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get(weatherFallbackHeader) == "capital"; got != tt.wantFallback {
+				t.Errorf("%s = %q, want fallback %v", weatherFallbackHeader, rec.Header().Get(weatherFallbackHeader), tt.wantFallback)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(queries) != fmt.Sprint(tt.wantQueries) {
+				t.Errorf("weather queries = %q, want %q", queries, tt.wantQueries)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// viaCEPBaseURL is set from VIACEP_BASE_URL.
+	viaCEPBaseURL = "https://viacep.com.br/ws"
+
+	brasilAPIBaseURL = "https://brasilapi.com.br/api/cep/v2"
+
+	// fallbackCity is the weather query used for CEPs that resolve to
+	// neither a city nor a UF; set from FALLBACK_CITY. Empty disables it.
+	fallbackCity string
+
+	// cepRefreshSlots bounds concurrent background cache refreshes; its
+	// size comes from CEP_CACHE_MAX_REFRESHES.
+	cepRefreshSlots = make(chan struct{}, 4)
+)
+
+type viaCEPResp struct {
+	Localidade  string `json:"localidade"`
+	UF          string `json:"uf"`
+	Bairro      string `json:"bairro"`
+	Complemento string `json:"complemento"`
+	Erro        string `json:"erro"`
+}
+
+type brasilAPIResp struct {
+	City         string `json:"city"`
+	State        string `json:"state"`
+	Neighborhood string `json:"neighborhood"`
+	Location     struct {
+		Coordinates struct {
+			Latitude  string `json:"latitude"`
+			Longitude string `json:"longitude"`
+		} `json:"coordinates"`
+	} `json:"location"`
+}
+
+// location is what a CEP resolves to. Lat and Lon are only set when the
+// provider that answered returned coordinates.
+type location struct {
+	City string
+	UF   string
+	Lat  *float64
+	Lon  *float64
+
+	// Neighborhood and Complement are empty when the provider has none.
+	Neighborhood string
+	Complement   string
+
+	// Provider is the CEP service that resolved the location.
+	Provider string
+}
+
+func (l location) hasCoordinates() bool {
+	return l.Lat != nil && l.Lon != nil
+}
+
+// resolveLocation returns the location for cep, serving from cepCache when
+// possible and refreshing entries close to expiry in the background.
+func resolveLocation(ctx context.Context, cep string) (location, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "resolve location")
+	defer span.End()
+	span.SetAttributes(attribute.String("cep.code", cep))
+
+	if mockMode {
+		span.SetAttributes(attribute.Bool("mock", true))
+		span.SetAttributes(locationAttrs(mockLocation)...)
+		return mockLocation, nil
+	}
+
+	if loc, ok, refresh := cepCache.Get(cep); ok {
+		span.SetAttributes(attribute.Bool("cache.hit", true), attribute.Bool("cache.refresh", refresh))
+		span.SetAttributes(locationAttrs(loc)...)
+		if refresh {
+			refreshLocation(ctx, cep)
+		}
+		return loc, nil
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+
+	loc, err := lookupLocation(ctx, cep)
+	if err != nil {
+		return location{}, err
+	}
+	span.SetAttributes(attribute.String("cep.provider", loc.Provider))
+	span.SetAttributes(locationAttrs(loc)...)
+
+	cepCache.Set(cep, loc)
+	return loc, nil
+}
+
+// refreshLocation looks cep up again in the background and replaces its
+// cache entry. At most cap(cepRefreshSlots) refreshes run at once; when all
+// are busy the refresh is left to a later request.
+func refreshLocation(ctx context.Context, cep string) {
+	select {
+	case cepRefreshSlots <- struct{}{}:
+	default:
+		cepCache.RefreshFailed(cep)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), upstreamTimeout)
+	go func() {
+		defer cancel()
+		defer func() { <-cepRefreshSlots }()
+		loc, err := lookupLocation(ctx, cep)
+		if err != nil {
+			slog.WarnContext(ctx, "cep cache refresh failed", "cep", cep, "error", err)
+			cepCache.RefreshFailed(cep)
+			return
+		}
+		cepCache.Set(cep, loc)
+	}()
+}
+
+// lookupLocation asks the CEP providers for cep. viacep is tried first;
+// BrasilAPI is used when viacep errors or returns a non-200. A "not found"
+// answer from viacep is final and is not retried.
+func lookupLocation(ctx context.Context, cep string) (location, error) {
+	provider := "viacep"
+	loc, err := lookupViaCEP(ctx, cep)
+	// No fallback once ctx is done: the client is gone or out of time.
+	if err != nil && !errors.Is(err, errZipcodeNotFound) && ctx.Err() == nil {
+		slog.WarnContext(ctx, "viacep lookup failed, falling back to brasilapi", "error", err)
+		provider = "brasilapi"
+		var fallbackErr error
+		loc, fallbackErr = lookupBrasilAPI(ctx, cep)
+		if fallbackErr == nil || errors.Is(fallbackErr, errZipcodeNotFound) {
+			err = fallbackErr
+		} else {
+			// Keep both causes so callers can tell a timeout or a
+			// malformed viacep response from a generic failure.
+			err = errors.Join(err, fallbackErr)
+		}
+	}
+	if err != nil {
+		return location{}, err
+	}
+	loc.Provider = provider
+	return loc, nil
+}
+
+// locationAttrs describes a resolved location for spans.
+func locationAttrs(loc location) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cep.city", loc.City),
+		attribute.String("cep.uf", loc.UF),
+	}
+}
+
+// maxLoggedBodyBytes caps how much of an undecodable upstream body is logged.
+const maxLoggedBodyBytes = 256
+
+// upstreamDecodeError means a provider answered with a body that is not the
+// JSON we expect, e.g. an HTML error page during an outage.
+type upstreamDecodeError struct {
+	provider string
+	err      error
+}
+
+func (e *upstreamDecodeError) Error() string {
+	return fmt.Sprintf("decode %s response: %v", e.provider, e.err)
+}
+
+func (e *upstreamDecodeError) Unwrap() error { return e.err }
+
+// decodeUpstream decodes provider's JSON body into dst. On failure it records
+// the error on the current span and logs the start of the body.
+func decodeUpstream(ctx context.Context, provider string, body io.Reader, dst any) error {
+	b, err := io.ReadAll(body)
+	if err == nil {
+		err = json.Unmarshal(b, dst)
+	}
+	if err == nil {
+		return nil
+	}
+
+	derr := &upstreamDecodeError{provider: provider, err: err}
+	trace.SpanFromContext(ctx).RecordError(derr)
+	snippet := b[:min(len(b), maxLoggedBodyBytes)]
+	slog.WarnContext(ctx, "undecodable upstream response",
+		"provider", provider, "error", err, "body", string(snippet), "body_bytes", len(b))
+	return derr
+}
+
+func lookupViaCEP(ctx context.Context, cep string) (location, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "viaCEP lookup")
+	defer span.End()
+	defer metrics.ObserveUpstream("viacep", time.Now())
+
+	url := fmt.Sprintf("%s/%s/json/", viaCEPBaseURL, cep)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return location{}, err
+	}
+	resp, err := doWithRetry(req)
+	if err != nil {
+		return location{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, _ := retryAfter(resp)
+		return location{}, &throttledError{provider: "viacep", retryAfter: wait}
+	}
+	if resp.StatusCode != 200 {
+		return location{}, fmt.Errorf("viacep status %d", resp.StatusCode)
+	}
+	var v viaCEPResp
+	if err = decodeUpstream(ctx, "viacep", resp.Body, &v); err != nil {
+		return location{}, err
+	}
+	if v.Erro == "true" {
+		return location{}, errZipcodeNotFound
+	}
+	city := v.Localidade
+	if city == "" {
+		var ok bool
+		if city, ok = cityFallback(ctx, v.UF); !ok {
+			return location{}, errZipcodeNotFound
+		}
+	}
+	return location{City: city, UF: v.UF, Neighborhood: v.Bairro, Complement: v.Complemento}, nil
+}
+
+// cityFallback picks a weather query for a CEP whose provider returned no
+// city, as happens with some rural CEPs: the UF when known, else
+// fallbackCity. It notes the substitution on the current span.
+func cityFallback(ctx context.Context, uf string) (string, bool) {
+	city := uf
+	if city == "" {
+		city = fallbackCity
+	}
+	if city == "" {
+		return "", false
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cep.city_fallback", city))
+	return city, true
+}
+
+// lookupBrasilAPI uses BrasilAPI's v2 endpoint, which also returns the CEP's
+// coordinates when known.
+func lookupBrasilAPI(ctx context.Context, cep string) (location, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "brasilapi lookup")
+	defer span.End()
+	defer metrics.ObserveUpstream("brasilapi", time.Now())
+
+	url := fmt.Sprintf("%s/%s", brasilAPIBaseURL, cep)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return location{}, err
+	}
+	resp, err := doWithRetry(req)
+	if err != nil {
+		return location{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return location{}, errZipcodeNotFound
+	}
+	if resp.StatusCode != 200 {
+		return location{}, fmt.Errorf("brasilapi status %d", resp.StatusCode)
+	}
+	var b brasilAPIResp
+	if err = decodeUpstream(ctx, "brasilapi", resp.Body, &b); err != nil {
+		return location{}, err
+	}
+	city := b.City
+	if city == "" {
+		var ok bool
+		if city, ok = cityFallback(ctx, b.State); !ok {
+			return location{}, errZipcodeNotFound
+		}
+	}
+
+	loc := location{City: city, UF: b.State, Neighborhood: b.Neighborhood}
+	lat, latErr := strconv.ParseFloat(b.Location.Coordinates.Latitude, 64)
+	lon, lonErr := strconv.ParseFloat(b.Location.Coordinates.Longitude, 64)
+	if latErr == nil && lonErr == nil {
+		loc.Lat, loc.Lon = &lat, &lon
+	}
+	return loc, nil
+}
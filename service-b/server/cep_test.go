@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// withBrasilAPI serves BrasilAPI lookups from h for the duration of the
+// test. It is meant to follow withViaCEP.
+func withBrasilAPI(t *testing.T, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	prev := brasilAPIBaseURL
+	brasilAPIBaseURL = srv.URL
+	t.Cleanup(func() { brasilAPIBaseURL = prev })
+}
+
+func TestLookupLocationFallback(t *testing.T) {
+	const brasilAPIFound = `{"city":"São Paulo","state":"SP","neighborhood":"Sé",` +
+		`"location":{"coordinates":{"latitude":"-23.55","longitude":"-46.63"}}}`
+	tests := []struct {
+		name          string
+		viaCEPStatus  int
+		viaCEPBody    string
+		brasilAPIBody string
+		wantProvider  string
+		wantErr       error
+		wantBrasilAPI bool
+	}{
+		{"viacep answers", http.StatusOK, `{"localidade":"São Paulo","uf":"SP"}`, brasilAPIFound, "viacep", nil, false},
+		{"viacep fails", http.StatusInternalServerError, "", brasilAPIFound, "brasilapi", nil, true},
+		{"viacep not found is final", http.StatusOK, `{"erro":"true"}`, brasilAPIFound, "", errZipcodeNotFound, false},
+		{"both fail", http.StatusInternalServerError, "", "", "", nil, true},
+		{"brasilapi not found", http.StatusInternalServerError, "", "not found", "", errZipcodeNotFound, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.viaCEPStatus)
+				w.Write([]byte(tt.viaCEPBody))
+			}))
+			var brasilAPICalls atomic.Int32
+			withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				brasilAPICalls.Add(1)
+				switch tt.brasilAPIBody {
+				case "":
+					w.WriteHeader(http.StatusBadGateway)
+				case "not found":
+					w.WriteHeader(http.StatusNotFound)
+				default:
+					w.Write([]byte(tt.brasilAPIBody))
+				}
+			}))
+
+			loc, err := lookupLocation(context.Background(), "01001000")
+			if got := brasilAPICalls.Load() > 0; got != tt.wantBrasilAPI {
+				t.Errorf("brasilapi called = %v, want %v", got, tt.wantBrasilAPI)
+			}
+			if tt.wantProvider == "" {
+				if err == nil {
+					t.Fatalf("lookupLocation = %+v, want an error", loc)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.City != "São Paulo" || loc.UF != "SP" || loc.Provider != tt.wantProvider {
+				t.Errorf("location = %+v, want São Paulo/SP from %s", loc, tt.wantProvider)
+			}
+		})
+	}
+}
+
+func TestLookupBrasilAPICoordinates(t *testing.T) {
+	withViaCEP(t, http.NotFoundHandler())
+	withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/01001000" {
+			t.Errorf("path = %q, want /01001000", r.URL.Path)
+		}
+		w.Write([]byte(`{"city":"São Paulo","state":"SP","location":{"coordinates":{"latitude":"-23.55","longitude":"-46.63"}}}`))
+	}))
+
+	loc, err := lookupBrasilAPI(context.Background(), "01001000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loc.hasCoordinates() || *loc.Lat != -23.55 || *loc.Lon != -46.63 {
+		t.Errorf("coordinates = %v, %v; want -23.55, -46.63", loc.Lat, loc.Lon)
+	}
+}
+
+func TestUpstreamBaseURLPaths(t *testing.T) {
+	var paths []string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/ws/") {
+			w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			return
+		}
+		w.Write([]byte(`{"current":{"temp_c":20}}`))
+	})
+	withViaCEP(t, record)
+	withWeatherAPI(t, record)
+	viaCEPBaseURL = baseURL(viaCEPBaseURL + "/ws/")
+	weatherBaseURL = baseURL(weatherBaseURL + "/v1/")
+
+	if rec := getWeather(t, "/weather?cep=01001000"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	want := []string{"/ws/01001000/json/", "/v1/current.json"}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+}
+
+func TestDecodeUpstream(t *testing.T) {
+	buf := captureLogs(t)
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "lookup")
+
+	html := "<html><body>" + strings.Repeat("503 Service Unavailable ", 100) + "</body></html>"
+	var v struct{ Localidade string }
+	err := decodeUpstream(ctx, "viacep", strings.NewReader(html), &v)
+	span.End()
+
+	var derr *upstreamDecodeError
+	if !errors.As(err, &derr) || derr.provider != "viacep" {
+		t.Fatalf("err = %v, want an upstreamDecodeError from viacep", err)
+	}
+
+	records := logRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("got %d log records, want 1", len(records))
+	}
+	if body, _ := records[0]["body"].(string); body != html[:maxLoggedBodyBytes] {
+		t.Errorf("logged body = %q, want the first %d bytes", body, maxLoggedBodyBytes)
+	}
+	if n, _ := records[0]["body_bytes"].(float64); int(n) != len(html) {
+		t.Errorf("body_bytes = %v, want %d", records[0]["body_bytes"], len(html))
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if events := spans[0].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("span events = %+v, want one exception", events)
+	}
+
+	if err := decodeUpstream(ctx, "viacep", strings.NewReader(`{"Localidade":"Sé"}`), &v); err != nil || v.Localidade != "Sé" {
+		t.Errorf("decodeUpstream(valid JSON) = %v, %q; want nil, Sé", err, v.Localidade)
+	}
+}
+
+func TestHandleWeatherUpstreamDecodeError(t *testing.T) {
+	html := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>maintenance</html>"))
+	})
+	withViaCEP(t, html)
+	withBrasilAPI(t, html)
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502: %s", rec.Code, rec.Body)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "upstream_decode_error" {
+		t.Errorf("code = %q, want upstream_decode_error", e.Error.Code)
+	}
+}
+
+func TestLookupViaCEPCityFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		fallbackCity string
+		wantCity     string
+		wantFallback bool
+		wantErr      error
+	}{
+		{"city", `{"localidade":"Rio Branco","uf":"AC"}`, "", "Rio Branco", false, nil},
+		{"uf", `{"localidade":"","bairro":"Zona Rural","uf":"AC"}`, "Brasília", "AC", true, nil},
+		{"fallback city", `{"localidade":"","uf":""}`, "Brasília", "Brasília", true, nil},
+		{"nothing usable", `{"localidade":"","uf":""}`, "", "", false, errZipcodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := recordSpans(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			prev := fallbackCity
+			fallbackCity = tt.fallbackCity
+			t.Cleanup(func() { fallbackCity = prev })
+
+			loc, err := lookupViaCEP(context.Background(), "69900970")
+			if !errors.Is(err, tt.wantErr) || loc.City != tt.wantCity {
+				t.Fatalf("lookupViaCEP = %q, %v; want %q, %v", loc.City, err, tt.wantCity, tt.wantErr)
+			}
+
+			got, noted := spanAttrs(t, sr, "viaCEP lookup")["cep.city_fallback"]
+			if noted != tt.wantFallback || (noted && got.AsString() != tt.wantCity) {
+				t.Errorf("cep.city_fallback = %q (set %v), want set %v", got.AsString(), noted, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherProviders(t *testing.T) {
+	tests := []struct {
+		name            string
+		viaCEPStatus    int
+		weatherProvider string
+		weatherBody     string
+		wantCEP         string
+		wantWeather     string
+	}{
+		{"viacep and weatherapi", http.StatusOK, "weatherapi", `{"current":{"temp_c":20}}`, "viacep", "weatherapi"},
+		{"brasilapi fallback", http.StatusInternalServerError, "weatherapi", `{"current":{"temp_c":20}}`, "brasilapi", "weatherapi"},
+		{"openweathermap", http.StatusOK, "openweathermap", `{"main":{"temp":20,"humidity":80},"wind":{"speed":5}}`, "viacep", "openweathermap"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.viaCEPStatus)
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"city":"São Paulo","state":"SP"}`))
+			}))
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.weatherBody))
+			}))
+			weatherProviderName = tt.weatherProvider
+			fetchWeather = weatherProviders[tt.weatherProvider].fetch
+
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var got out
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.CEPProvider != tt.wantCEP || got.WeatherProvider != tt.wantWeather {
+				t.Errorf("providers = %s/%s, want %s/%s", got.CEPProvider, got.WeatherProvider, tt.wantCEP, tt.wantWeather)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+)
+
+// chaosFailureRate is the probability that a /weather request fails with
+// chaos_injected before any upstream call, for exercising clients' retries
+// and alerts. It is 0 unless CHAOS_ENABLED is set; then it comes from
+// CHAOS_FAILURE_RATE.
+var chaosFailureRate float64
+
+// parseChaosRate reads a CHAOS_FAILURE_RATE value between 0 and 1.
+func parseChaosRate(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%q is not a rate between 0 and 1", v)
+	}
+	return rate, nil
+}
+
+// injectChaos reports whether this request should fail on purpose.
+func injectChaos() bool {
+	return chaosFailureRate > 0 && rand.Float64() < chaosFailureRate
+}
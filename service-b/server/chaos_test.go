@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+func TestParseChaosRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"0.25", 0.25, false},
+		{"1", 1, false},
+		{"1.5", 0, true},
+		{"-0.1", 0, true},
+		{"half", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseChaosRate(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseChaosRate(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestInjectChaosRate(t *testing.T) {
+	prev := chaosFailureRate
+	t.Cleanup(func() { chaosFailureRate = prev })
+
+	tests := []struct {
+		rate     float64
+		min, max int
+	}{
+		{0, 0, 0},
+		{1, 1000, 1000},
+		{0.5, 400, 600},
+	}
+	for _, tt := range tests {
+		chaosFailureRate = tt.rate
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if injectChaos() {
+				n++
+			}
+		}
+		if n < tt.min || n > tt.max {
+			t.Errorf("rate %v: %d of 1000 requests failed, want %d to %d", tt.rate, n, tt.min, tt.max)
+		}
+	}
+}
+
+func TestHandleWeatherChaos(t *testing.T) {
+	sr := recordSpans(t)
+	var upstreamCalls atomic.Int32
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	prev := chaosFailureRate
+	chaosFailureRate = 1
+	t.Cleanup(func() { chaosFailureRate = prev })
+
+	rec := httptest.NewRecorder()
+	h := otelhttp.NewHandler(http.HandlerFunc(handleWeather), "handleWeather")
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "chaos_injected" {
+		t.Errorf("code = %q, want chaos_injected", e.Error.Code)
+	}
+	if n := upstreamCalls.Load(); n != 0 {
+		t.Errorf("viacep called %d times, want chaos to fail before any upstream call", n)
+	}
+	if v, ok := spanAttrs(t, sr, "handleWeather")["chaos.injected"]; !ok || !v.AsBool() {
+		t.Errorf("chaos.injected = %v, want true on the request span", v)
+	}
+
+	// Invalid input is still rejected as such rather than by chaos.
+	if rec := getWeather(t, "/weather?cep=123"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid cep: status = %d, want 422", rec.Code)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// clock abstracts the passage of time for cache expiry and retry backoff so
+// both can be driven without real waiting.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// jitter returns a random duration in [0, d) for spreading out retries and
+// cache refreshes; d must be positive.
+var jitter = rand.N[time.Duration]
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to. After does not block:
+// it records the wait, advances the clock by it and fires at once.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// withFakeTime replaces retryClock and jitter for the duration of the test.
+// jitter returns d-1, its largest value, so waits are predictable.
+func withFakeTime(t *testing.T) *fakeClock {
+	t.Helper()
+	clk := newFakeClock()
+	prevClock, prevJitter := retryClock, jitter
+	retryClock = clk
+	jitter = func(d time.Duration) time.Duration { return d - 1 }
+	t.Cleanup(func() { retryClock, jitter = prevClock, prevJitter })
+	return clk
+}
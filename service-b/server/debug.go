@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+
+	"service-b/platform"
+)
+
+// redacted replaces secrets in /debug/config.
+const redacted = "[REDACTED]"
+
+// debugConfig is the configuration service-b is actually running with, as
+// reported by /debug/config.
+type debugConfig struct {
+	HTTPAddr                     string        `json:"httpAddr"`
+	MockMode                     bool          `json:"mockMode"`
+	WeatherProvider              string        `json:"weatherProvider"`
+	WeatherAPIKey                string        `json:"weatherApiKey"`
+	WeatherBaseURL               string        `json:"weatherBaseUrl"`
+	ViaCEPBaseURL                string        `json:"viaCepBaseUrl"`
+	FallbackCity                 string        `json:"fallbackCity"`
+	UpstreamTimeoutMs            int64         `json:"upstreamTimeoutMs"`
+	WeatherMinBudgetMs           int64         `json:"weatherMinBudgetMs"`
+	UpstreamMaxRetries           int           `json:"upstreamMaxRetries"`
+	MaxConcurrentUpstream        int           `json:"maxConcurrentUpstream"`
+	CEPCacheTTLSeconds           int64         `json:"cepCacheTtlSeconds"`
+	CEPCacheRefreshWindowSeconds int64         `json:"cepCacheRefreshWindowSeconds"`
+	CEPCacheMaxRefreshes         int           `json:"cepCacheMaxRefreshes"`
+	CEPCacheMaxEntries           int           `json:"cepCacheMaxEntries"`
+	WeatherCacheSeconds          int           `json:"weatherCacheSeconds"`
+	ServeStale                   bool          `json:"serveStale"`
+	StaleMaxEntries              int           `json:"staleMaxEntries"`
+	StaleMaxAgeSeconds           int64         `json:"staleMaxAgeSeconds"`
+	PartialOnWeatherFailure      bool          `json:"partialOnWeatherFailure"`
+	ChaosFailureRate             float64       `json:"chaosFailureRate"`
+	TempDecimals                 tempPrecision `json:"tempDecimals"`
+	CamelOutput                  bool          `json:"camelOutput"`
+	UserAgent                    string        `json:"userAgent"`
+	DebugUpstream                bool          `json:"debugUpstream"`
+	TLSMinVersion                string        `json:"tlsMinVersion"`
+	MaxIdleConnsPerHost          int           `json:"maxIdleConnsPerHost"`
+	IdleConnTimeoutMs            int64         `json:"idleConnTimeoutMs"`
+	OTLPEndpoint                 string        `json:"otlpEndpoint"`
+	ServiceName                  string        `json:"serviceName"`
+}
+
+// effectiveConfig snapshots the configuration main has loaded. Secrets are
+// only reported as set or not.
+func effectiveConfig(addr, otlpEndpoint, serviceName string) debugConfig {
+	cfg := debugConfig{
+		HTTPAddr:                     addr,
+		MockMode:                     mockMode,
+		WeatherProvider:              weatherProviderName,
+		WeatherBaseURL:               weatherBaseURL,
+		ViaCEPBaseURL:                viaCEPBaseURL,
+		FallbackCity:                 fallbackCity,
+		UpstreamTimeoutMs:            upstreamTimeout.Milliseconds(),
+		WeatherMinBudgetMs:           weatherMinBudget.Milliseconds(),
+		UpstreamMaxRetries:           upstreamMaxRetries,
+		MaxConcurrentUpstream:        cap(upstreamSlots),
+		CEPCacheTTLSeconds:           int64(cepCache.ttl.Seconds()),
+		CEPCacheRefreshWindowSeconds: int64(cepCache.refreshWindow.Seconds()),
+		CEPCacheMaxRefreshes:         cap(cepRefreshSlots),
+		CEPCacheMaxEntries:           cepCache.maxEntries,
+		WeatherCacheSeconds:          weatherCacheSeconds,
+		ServeStale:                   serveStale,
+		StaleMaxEntries:              lastGood.maxEntries,
+		StaleMaxAgeSeconds:           int64(lastGood.maxAge.Seconds()),
+		PartialOnWeatherFailure:      partialOnWeatherFailure,
+		ChaosFailureRate:             chaosFailureRate,
+		TempDecimals:                 tempDecimals,
+		CamelOutput:                  camelOutput,
+		UserAgent:                    platform.UserAgent,
+		DebugUpstream:                platform.DebugUpstream,
+		TLSMinVersion:                tls.VersionName(platform.TLSMinVersion),
+		MaxIdleConnsPerHost:          platform.MaxIdleConnsPerHost,
+		IdleConnTimeoutMs:            platform.IdleConnTimeout.Milliseconds(),
+		OTLPEndpoint:                 otlpEndpoint,
+		ServiceName:                  serviceName,
+	}
+	if weatherAPIKey != "" {
+		cfg.WeatherAPIKey = redacted
+	}
+	if mockMode {
+		cfg.WeatherProvider = "mock"
+	}
+	return cfg
+}
+
+// handleDebugConfig serves cfg. It is only registered when
+// ENABLE_DEBUG_ENDPOINTS is set, and is intentionally not traced.
+func handleDebugConfig(cfg debugConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cfg)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugConfigEndpoint(t *testing.T) {
+	prevKey := weatherAPIKey
+	weatherAPIKey = "0123456789abcdef0123456789abcdef"
+	t.Cleanup(func() { weatherAPIKey = prevKey })
+
+	tests := []struct {
+		enable     string
+		wantStatus int
+	}{
+		{"", http.StatusNotFound},
+		{"false", http.StatusNotFound},
+		{"true", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Setenv("ENABLE_DEBUG_ENDPOINTS", tt.enable)
+		rec := httptest.NewRecorder()
+		newMux(":8080", "http://collector:4318", "service-b").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("ENABLE_DEBUG_ENDPOINTS=%q: status = %d, want %d", tt.enable, rec.Code, tt.wantStatus)
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+
+		if strings.Contains(rec.Body.String(), weatherAPIKey) {
+			t.Errorf("/debug/config leaks the API key: %s", rec.Body)
+		}
+		var cfg debugConfig
+		if err := json.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.WeatherAPIKey != redacted || cfg.HTTPAddr != ":8080" || cfg.OTLPEndpoint != "http://collector:4318" {
+			t.Errorf("config = %+v, want the redacted key and the given addresses", cfg)
+		}
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	prevKey, prevMock := weatherAPIKey, mockMode
+	t.Cleanup(func() { weatherAPIKey, mockMode = prevKey, prevMock })
+
+	tests := []struct {
+		key          string
+		mock         bool
+		wantKey      string
+		wantProvider string
+	}{
+		{"0123456789abcdef0123456789abcdef", false, redacted, weatherProviderName},
+		{"", false, "", weatherProviderName},
+		{"", true, "", "mock"},
+	}
+	for _, tt := range tests {
+		weatherAPIKey, mockMode = tt.key, tt.mock
+		cfg := effectiveConfig(":8080", "", "service-b")
+		if cfg.WeatherAPIKey != tt.wantKey || cfg.WeatherProvider != tt.wantProvider {
+			t.Errorf("key %q, mock %v: weatherApiKey %q, provider %q; want %q, %q",
+				tt.key, tt.mock, cfg.WeatherAPIKey, cfg.WeatherProvider, tt.wantKey, tt.wantProvider)
+		}
+	}
+}
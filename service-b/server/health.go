@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deepHealthTimeout bounds each dependency check of /health/deep.
+const deepHealthTimeout = 2 * time.Second
+
+// dependency is an upstream /health/deep checks. Only critical dependencies
+// make the service unhealthy when down.
+type dependency struct {
+	name     string
+	url      string
+	critical bool
+}
+
+type dependencyHealth struct {
+	Name      string `json:"name"`
+	Critical  bool   `json:"critical"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type deepHealthResp struct {
+	Status       string             `json:"status"`
+	Dependencies []dependencyHealth `json:"dependencies"`
+}
+
+// dependencies lists what service-b needs to answer /weather. In mock mode
+// nothing is called, so there is nothing to check.
+func dependencies() []dependency {
+	if mockMode {
+		return nil
+	}
+	return []dependency{
+		{name: "viacep", url: viaCEPBaseURL + "/01001000/json/", critical: true},
+		{name: "brasilapi", url: brasilAPIBaseURL + "/01001000"},
+		{name: weatherProviderName, url: weatherBaseURL, critical: true},
+	}
+}
+
+// checkDependency sends d a GET request. Any answer below 500 counts as
+// healthy: the check is about reachability, and without a key or a real
+// query providers legitimately answer 4xx.
+func checkDependency(ctx context.Context, d dependency) dependencyHealth {
+	h := dependencyHealth{Name: d.name, Critical: d.critical, Status: "ok"}
+	ctx, cancel := context.WithTimeout(ctx, deepHealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = httpClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				h.Error = http.StatusText(resp.StatusCode)
+			}
+		}
+	}
+	h.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		h.Error = err.Error()
+	}
+	if h.Error != "" {
+		h.Status = "down"
+	}
+	return h
+}
+
+// handleDeepHealth checks every dependency concurrently and answers 503
+// unless all critical ones are reachable. It is intentionally not traced.
+func handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	deps := dependencies()
+	resp := deepHealthResp{Status: "ok", Dependencies: make([]dependencyHealth, len(deps))}
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp.Dependencies[i] = checkDependency(r.Context(), d)
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, h := range resp.Dependencies {
+		switch {
+		case h.Status == "ok":
+		case h.Critical:
+			resp.Status = "down"
+			status = http.StatusServiceUnavailable
+		case resp.Status == "ok":
+			resp.Status = "degraded"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// statusHandler answers every request with status.
+func statusHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+}
+
+func TestHandleDeepHealth(t *testing.T) {
+	tests := []struct {
+		name                                   string
+		viaCEP, brasilAPI, weather             int
+		wantStatus                             int
+		wantOverall                            string
+		wantViaCEP, wantBrasilAPI, wantWeather string
+	}{
+		{"all up", http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, "ok", "ok", "ok", "ok"},
+		{"4xx counts as reachable", http.StatusOK, http.StatusNotFound, http.StatusUnauthorized, http.StatusOK, "ok", "ok", "ok", "ok"},
+		{"optional provider down", http.StatusOK, http.StatusBadGateway, http.StatusOK, http.StatusOK, "degraded", "ok", "down", "ok"},
+		{"critical provider down", http.StatusServiceUnavailable, http.StatusOK, http.StatusOK, http.StatusServiceUnavailable, "down", "down", "ok", "ok"},
+		{"weather provider down", http.StatusOK, http.StatusBadGateway, http.StatusInternalServerError, http.StatusServiceUnavailable, "down", "ok", "down", "down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withViaCEP(t, statusHandler(tt.viaCEP))
+			withBrasilAPI(t, statusHandler(tt.brasilAPI))
+			withWeatherAPI(t, statusHandler(tt.weather))
+
+			rec := httptest.NewRecorder()
+			handleDeepHealth(rec, httptest.NewRequest(http.MethodGet, "/health/deep", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var got deepHealthResp
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantOverall {
+				t.Errorf("overall status = %q, want %q", got.Status, tt.wantOverall)
+			}
+			want := map[string]string{"viacep": tt.wantViaCEP, "brasilapi": tt.wantBrasilAPI, "weatherapi": tt.wantWeather}
+			if len(got.Dependencies) != len(want) {
+				t.Fatalf("dependencies = %+v, want %d of them", got.Dependencies, len(want))
+			}
+			for _, d := range got.Dependencies {
+				if d.Status != want[d.Name] {
+					t.Errorf("%s status = %q, want %q", d.Name, d.Status, want[d.Name])
+				}
+				if (d.Error != "") != (d.Status == "down") {
+					t.Errorf("%s error = %q with status %q", d.Name, d.Error, d.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleDeepHealthMockMode(t *testing.T) {
+	withMockMode(t)
+
+	rec := httptest.NewRecorder()
+	handleDeepHealth(rec, httptest.NewRequest(http.MethodGet, "/health/deep", nil))
+	var got deepHealthResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.Status != "ok" || len(got.Dependencies) != 0 {
+		t.Errorf("mock mode: %d %+v, want 200 ok with no dependencies", rec.Code, got)
+	}
+}
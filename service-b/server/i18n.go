@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+
+	"service-b/platform"
+)
+
+// messages holds service-b's translated error messages keyed by language and
+// error code. English messages are the ones passed to platform.WriteError, so
+// only other languages need entries here.
+var messages = map[string]map[string]string{
+	"pt-BR": {
+		"aqi_unsupported":         "qualidade do ar não suportada pelo provedor de clima",
+		"bad_gateway":             "erro ao consultar serviço externo",
+		"body_too_large":          "corpo da requisição muito grande",
+		"chaos_injected":          "falha injetada para testes",
+		"forecast_unsupported":    "previsão não suportada pelo provedor de clima",
+		"gateway_timeout":         "tempo esgotado ao consultar serviço externo",
+		"internal_error":          "erro interno",
+		"invalid_aqi":             "valor de aqi inválido",
+		"invalid_days":            fmt.Sprintf("days deve ser um inteiro entre 1 e %d", maxForecastDays),
+		"invalid_format":          "valor de format inválido",
+		"invalid_lang":            "valor de lang inválido",
+		"invalid_request":         "corpo da requisição inválido",
+		"invalid_units":           "valor de units inválido",
+		"invalid_zipcode":         "CEP inválido",
+		"location_not_found":      "localização não encontrada",
+		"method_not_allowed":      "método não permitido",
+		"too_many_ceps":           "CEPs demais na requisição",
+		"upstream_decode_error":   "resposta inválida do serviço externo",
+		"upstream_quota_exceeded": "cota do provedor de clima esgotada",
+		"upstream_saturated":      "muitas consultas simultâneas ao provedor de clima",
+		"upstream_throttled":      "serviço externo limitando requisições",
+		"upstream_too_large":      "resposta do serviço externo muito grande",
+		"zipcode_not_found":       "CEP não encontrado",
+	},
+}
+
+func init() { platform.AddMessages(messages) }
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"service-b/platform"
+)
+
+func TestWriteErrorLocalized(t *testing.T) {
+	tests := []struct {
+		acceptLanguage, code, wantMessage string
+	}{
+		{"pt-BR", "invalid_zipcode", "CEP inválido"},
+		{"en", "invalid_zipcode", "invalid zipcode"},
+		{"pt-BR", "untranslated_code", "invalid zipcode"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		rec := httptest.NewRecorder()
+		platform.WriteError(rec, req, http.StatusUnprocessableEntity, tt.code, "invalid zipcode")
+
+		e := decodeError(t, rec)
+		if e.Error.Code != tt.code || e.Error.Message != tt.wantMessage {
+			t.Errorf("Accept-Language %s, code %s: got %+v, want message %q and the code untranslated",
+				tt.acceptLanguage, tt.code, e.Error, tt.wantMessage)
+		}
+	}
+}
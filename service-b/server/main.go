@@ -0,0 +1,710 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"service-b/platform"
+)
+
+var (
+	cepRegex = regexp.MustCompile(`^\d{8}$`)
+
+	errZipcodeNotFound = errors.New("zipcode not found")
+
+	// upstreamTimeout bounds each request's upstream calls; set from UPSTREAM_TIMEOUT_MS.
+	upstreamTimeout = 10 * time.Second
+
+	// weatherMinBudget is the least time left on the request deadline that
+	// the weather call is attempted with; set from WEATHER_MIN_BUDGET_MS.
+	weatherMinBudget = 500 * time.Millisecond
+
+	// upstreamMaxRetries is how many times a failed upstream call is retried;
+	// set from UPSTREAM_MAX_RETRIES.
+	upstreamMaxRetries = 2
+
+	// tempDecimals is the precision of the returned temperatures in each
+	// scale; set from TEMP_DECIMALS_C, TEMP_DECIMALS_F and TEMP_DECIMALS_K,
+	// which default to TEMP_DECIMALS.
+	tempDecimals = tempPrecision{C: 1, F: 1, K: 1}
+
+	// cepCache holds CEP-to-location lookups; its TTL comes from
+	// CEP_CACHE_TTL_SECONDS and its size from CEP_CACHE_MAX_ENTRIES.
+	cepCache = newLocationCache(time.Hour, 0, 10000)
+
+	// weatherAPIKey is read once at startup from WEATHER_API_KEY and is the
+	// credential for whichever provider WEATHER_PROVIDER selects.
+	weatherAPIKey string
+
+	// fetchWeather and fetchForecast come from the provider chosen by
+	// WEATHER_PROVIDER; fetchForecast is nil when it has no forecast support.
+	fetchWeather  weatherFetcher  = fetchWeatherAPI
+	fetchForecast forecastFetcher = fetchWeatherAPIForecast
+
+	// weatherProviderName is the WEATHER_PROVIDER in use.
+	weatherProviderName = "weatherapi"
+
+	// weatherCacheSeconds is the max-age of successful /weather responses;
+	// set from WEATHER_CACHE_SECONDS. 0 omits Cache-Control.
+	weatherCacheSeconds = 60
+
+	// airQualitySupported reports whether the chosen provider honors aqi.
+	airQualitySupported = true
+
+	// httpClient is shared by all outbound calls; built in main once the
+	// pool settings are read.
+	httpClient *http.Client
+)
+
+type out struct {
+	XMLName      xml.Name        `json:"-" xml:"weather"`
+	CEP          string          `json:"cep" xml:"cep"`
+	CEPFormatted string          `json:"cepFormatted" xml:"cepFormatted"`
+	City         string          `json:"city" xml:"city"`
+	State        string          `json:"state,omitempty" xml:"state,omitempty"`
+	Neighborhood string          `json:"neighborhood,omitempty" xml:"neighborhood,omitempty"`
+	Complement   string          `json:"complement,omitempty" xml:"complement,omitempty"`
+	Coordinates  *coordinatesOut `json:"coordinates,omitempty" xml:"coordinates,omitempty"`
+	TempC        *float64        `json:"temp_C,omitempty" xml:"temp_C,omitempty"`
+	TempF        *float64        `json:"temp_F,omitempty" xml:"temp_F,omitempty"`
+	TempK        *float64        `json:"temp_K,omitempty" xml:"temp_K,omitempty"`
+	Humidity     *int            `json:"humidity,omitempty" xml:"humidity,omitempty"`
+	WindKph      *float64        `json:"wind_kph,omitempty" xml:"wind_kph,omitempty"`
+	Condition    string          `json:"condition,omitempty" xml:"condition,omitempty"`
+
+	// CEPProvider and WeatherProvider name the services that answered.
+	CEPProvider     string `json:"cepProvider" xml:"cepProvider"`
+	WeatherProvider string `json:"weatherProvider" xml:"weatherProvider"`
+
+	// WeatherError replaces the weather fields when only the location could
+	// be found; see partialOnWeatherFailure.
+	WeatherError string `json:"weatherError,omitempty" xml:"weatherError,omitempty"`
+
+	AirQuality *airQualityOut `json:"air_quality,omitempty" xml:"air_quality,omitempty"`
+	Forecast   []forecastOut  `json:"forecast,omitempty" xml:"forecast>day,omitempty"`
+}
+
+type coordinatesOut struct {
+	Lat float64 `json:"lat" xml:"lat"`
+	Lon float64 `json:"lon" xml:"lon"`
+}
+
+type airQualityOut struct {
+	PM25 float64 `json:"pm2_5" xml:"pm2_5"`
+	PM10 float64 `json:"pm10" xml:"pm10"`
+}
+
+type forecastOut struct {
+	Date string   `json:"date" xml:"date"`
+	MinC *float64 `json:"min_C,omitempty" xml:"min_C,omitempty"`
+	MaxC *float64 `json:"max_C,omitempty" xml:"max_C,omitempty"`
+	MinF *float64 `json:"min_F,omitempty" xml:"min_F,omitempty"`
+	MaxF *float64 `json:"max_F,omitempty" xml:"max_F,omitempty"`
+	MinK *float64 `json:"min_K,omitempty" xml:"min_K,omitempty"`
+	MaxK *float64 `json:"max_K,omitempty" xml:"max_K,omitempty"`
+}
+
+// upstreamDurationHeader reports the milliseconds spent in the location and
+// weather lookups, for client-side latency debugging.
+const upstreamDurationHeader = "X-Upstream-Duration-Ms"
+
+// deadlineHeader carries the milliseconds the caller will still wait for the
+// response; service-b gives up before then rather than answer too late.
+const deadlineHeader = "X-Deadline-Ms"
+
+// deadlineBuffer is kept from a propagated deadline for the response to
+// travel back to the caller.
+const deadlineBuffer = 50 * time.Millisecond
+
+// maxForecastDays is the longest forecast the /weather days parameter allows.
+const maxForecastDays = 3
+
+// metrics counts service-b's requests and times its upstream calls, under
+// the service-b label.
+var metrics = platform.NewMetrics(prometheus.DefaultRegisterer, "service-b")
+
+// Main runs service-b as a standalone server until SIGINT or SIGTERM.
+func Main() {
+	platform.SetupLogger()
+
+	exporterEndpoint := platform.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	serviceName := platform.Getenv("OTEL_SERVICE_NAME", "service-b")
+	shutdown := platform.SetupTracer(exporterEndpoint, serviceName)
+	defer shutdown()
+	shutdownMeter := platform.SetupMeter(exporterEndpoint, serviceName)
+	defer shutdownMeter()
+	metrics.CreateInstruments(otel.GetMeterProvider().Meter(serviceName))
+
+	configure()
+
+	addr := platform.Getenv("HTTP_ADDR", ":8080")
+	mux := newMux(addr, exporterEndpoint, serviceName)
+
+	platform.ConfigureServer()
+	srv := platform.NewServer(addr, platform.WithGzip(platform.RecoverPanics(mux)))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		platform.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if err := platform.Serve(ctx, "service-b", srv, certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		platform.Fatal("server failed", "error", err)
+	}
+	slog.Info("service-b stopped")
+}
+
+// NewHandler configures service-b from the environment like Main does and
+// returns its routes without serving them. Logging and the tracer and meter
+// providers are left to the caller, whose global providers are used;
+// service-a uses it to run both services in one process.
+func NewHandler() http.Handler {
+	metrics.CreateInstruments(otel.GetMeterProvider().Meter("service-b"))
+	configure()
+	return platform.RecoverPanics(newMux(
+		platform.Getenv("HTTP_ADDR", ":8080"),
+		platform.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+		platform.Getenv("OTEL_SERVICE_NAME", "service-b"),
+	))
+}
+
+// configure reads service-b's settings from the environment. Invalid
+// settings are fatal.
+func configure() {
+	mockMode = os.Getenv("MOCK_MODE") == "true"
+	if mockMode {
+		slog.Warn("MOCK_MODE enabled: upstream providers will not be called")
+		fetchWeather = fetchMockWeather
+		fetchForecast = fetchMockForecast
+	} else {
+		key, err := validateWeatherAPIKey(os.Getenv("WEATHER_API_KEY"))
+		if err != nil {
+			platform.Fatal("invalid configuration", "error", err)
+		}
+		weatherAPIKey = key
+
+		weatherProviderName = platform.Getenv("WEATHER_PROVIDER", weatherProviderName)
+		p, ok := weatherProviders[weatherProviderName]
+		if !ok {
+			platform.Fatal("unknown WEATHER_PROVIDER (want weatherapi or openweathermap)", "provider", weatherProviderName)
+		}
+		fetchWeather = p.fetch
+		fetchForecast = p.forecast
+		airQualitySupported = p.airQuality
+		weatherBaseURL = baseURL(platform.Getenv("WEATHER_BASE_URL", p.baseURL))
+		viaCEPBaseURL = baseURL(platform.Getenv("VIACEP_BASE_URL", viaCEPBaseURL))
+		fallbackCity = os.Getenv("FALLBACK_CITY")
+	}
+
+	upstreamTimeout = platform.GetenvDurationMs("UPSTREAM_TIMEOUT_MS", upstreamTimeout)
+	platform.ConfigureHTTPClient()
+	httpClient = platform.NewHTTPClient()
+	weatherMinBudget = platform.GetenvDurationMs("WEATHER_MIN_BUDGET_MS", weatherMinBudget)
+	upstreamMaxRetries = max(platform.GetenvInt("UPSTREAM_MAX_RETRIES", upstreamMaxRetries), 0)
+	decimals := max(platform.GetenvInt("TEMP_DECIMALS", 1), 0)
+	tempDecimals = tempPrecision{
+		C: max(platform.GetenvInt("TEMP_DECIMALS_C", decimals), 0),
+		F: max(platform.GetenvInt("TEMP_DECIMALS_F", decimals), 0),
+		K: max(platform.GetenvInt("TEMP_DECIMALS_K", decimals), 0),
+	}
+	switch style := platform.Getenv("OUTPUT_STYLE", "snake"); style {
+	case "snake":
+	case "camel":
+		camelOutput = true
+	default:
+		platform.Fatal("unknown OUTPUT_STYLE (want snake or camel)", "style", style)
+	}
+	serveStale = os.Getenv("DEGRADED_SERVE_STALE") == "true"
+	if serveStale {
+		lastGood = newLastGoodStore(
+			platform.GetenvInt("DEGRADED_STALE_MAX_ENTRIES", lastGood.maxEntries),
+			time.Duration(max(platform.GetenvInt("DEGRADED_STALE_MAX_AGE_SECONDS", int(lastGood.maxAge.Seconds())), 0))*time.Second,
+		)
+	}
+	partialOnWeatherFailure = os.Getenv("PARTIAL_ON_WEATHER_FAILURE") == "true"
+	if os.Getenv("CHAOS_ENABLED") == "true" {
+		rate, err := parseChaosRate(os.Getenv("CHAOS_FAILURE_RATE"))
+		if err != nil {
+			platform.Fatal("invalid CHAOS_FAILURE_RATE", "error", err)
+		}
+		chaosFailureRate = rate
+		slog.Warn("CHAOS_ENABLED: /weather requests will fail on purpose", "rate", rate)
+	}
+	weatherCacheSeconds = max(platform.GetenvInt("WEATHER_CACHE_SECONDS", weatherCacheSeconds), 0)
+	if n := platform.GetenvInt("MAX_CONCURRENT_UPSTREAM", 0); n > 0 {
+		upstreamSlots = make(chan struct{}, n)
+	}
+	cepCache = newLocationCache(
+		time.Duration(platform.GetenvInt("CEP_CACHE_TTL_SECONDS", 3600))*time.Second,
+		time.Duration(max(platform.GetenvInt("CEP_CACHE_REFRESH_WINDOW_SECONDS", 300), 0))*time.Second,
+		platform.GetenvInt("CEP_CACHE_MAX_ENTRIES", cepCache.maxEntries),
+	)
+	go cepCache.sweepEvery(cepCacheSweepInterval)
+	cepRefreshSlots = make(chan struct{}, max(platform.GetenvInt("CEP_CACHE_MAX_REFRESHES", cap(cepRefreshSlots)), 1))
+
+	warmCEPsAtStartup(os.Getenv("WARM_CEPS"))
+}
+
+// newMux returns service-b's routes. addr, otlpEndpoint and serviceName are
+// only reported by /debug/config.
+func newMux(addr, otlpEndpoint, serviceName string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/weather", metrics.InstrumentHandler("weather", otelhttp.NewHandler(platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleWeather)))), "handleWeather")))
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/health/deep", handleDeepHealth)
+	mux.HandleFunc("/version", handleVersion)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if os.Getenv("ENABLE_DEBUG_ENDPOINTS") == "true" {
+		slog.Warn("ENABLE_DEBUG_ENDPOINTS enabled: /debug/config and /cache/warm are exposed")
+		mux.Handle("/debug/config", handleDebugConfig(effectiveConfig(addr, otlpEndpoint, serviceName)))
+		mux.Handle("/cache/warm", platform.WithRequestID(platform.LogRequests(platform.RecoverPanics(http.HandlerFunc(handleCacheWarm)))))
+	}
+	return mux
+}
+
+// maxRequestBodyBytes bounds the JSON body of POST /weather, which only
+// carries a CEP.
+const maxRequestBodyBytes = 4 << 10
+
+// weatherCEP reads the CEP from the JSON body of a POST, or from the "cep"
+// query parameter otherwise. It writes the error response and returns false
+// when a POST body can not be read.
+func weatherCEP(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		return r.URL.Query().Get("cep"), true
+	}
+	var payload struct {
+		CEP string `json:"cep"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&payload)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		platform.WriteError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+		return "", false
+	case err != nil:
+		platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return "", false
+	}
+	return payload.CEP, true
+}
+
+func handleWeather(w http.ResponseWriter, r *http.Request) {
+	if isMultiCEP(r) {
+		handleMultiWeather(w, r)
+		return
+	}
+	cep, ok := weatherCEP(w, r)
+	if !ok {
+		return
+	}
+	if !cepRegex.MatchString(cep) || allSameDigit(cep) {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_zipcode", "invalid zipcode")
+		return
+	}
+	span := oteltrace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("cep.code", cep))
+
+	days, err := parseDays(r.URL.Query().Get("days"))
+	if err != nil {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_days",
+			fmt.Sprintf("days must be an integer between 1 and %d", maxForecastDays))
+		return
+	}
+	if days > 0 && fetchForecast == nil {
+		platform.WriteError(w, r, http.StatusNotImplemented, "forecast_unsupported", "forecast not supported by weather provider")
+		return
+	}
+	units, err := parseUnits(r.URL.Query().Get("units"))
+	if err != nil {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_units", "invalid units")
+		return
+	}
+	decimals, err := parseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_format", "invalid format")
+		return
+	}
+	var opts weatherOptions
+	if v := r.URL.Query().Get("aqi"); v != "" {
+		if opts.AQI, err = strconv.ParseBool(v); err != nil {
+			platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_aqi", "invalid aqi")
+			return
+		}
+	}
+	if opts.Lang, err = parseLang(r.URL.Query().Get("lang")); err != nil {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "invalid_lang", "invalid lang")
+		return
+	}
+	if opts.AQI && !airQualitySupported {
+		platform.WriteError(w, r, http.StatusNotImplemented, "aqi_unsupported", "air quality not supported by weather provider")
+		return
+	}
+	if injectChaos() {
+		span.SetAttributes(attribute.Bool("chaos.injected", true))
+		platform.WriteError(w, r, http.StatusBadGateway, "chaos_injected", "failure injected for testing")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r))
+	defer cancel()
+
+	staleKey := lastGoodKey(cep, days, opts)
+	upstreamStart := time.Now()
+	loc, err := resolveLocation(ctx, cep)
+	upstreamElapsed := time.Since(upstreamStart)
+	if err != nil {
+		if errors.Is(err, errZipcodeNotFound) {
+			platform.WriteError(w, r, http.StatusNotFound, "zipcode_not_found", "can not find zipcode")
+			return
+		}
+		if res, ok := staleWeather(r, staleKey, err); ok {
+			writeWeather(w, r, cep, res, units, decimals, true)
+			return
+		}
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	span.SetAttributes(locationAttrs(loc)...)
+
+	var (
+		current  weatherCurrent
+		forecast []forecastDay
+	)
+	if platform.ClientCanceled(r, ctx.Err()) {
+		return
+	}
+	release, err := acquireUpstream(ctx)
+	if platform.ClientCanceled(r, err) {
+		return
+	}
+	if err != nil {
+		platform.WriteError(w, r, http.StatusServiceUnavailable, "upstream_saturated", "too many concurrent upstream calls")
+		return
+	}
+	// The CEP lookup shares the deadline; if it left too little time the
+	// weather call would only fail at the deadline, so give up now.
+	if left := timeLeft(ctx); left < weatherMinBudget {
+		release()
+		slog.WarnContext(ctx, "not enough time left for weather lookup", "left_ms", left.Milliseconds(), "min_ms", weatherMinBudget.Milliseconds())
+		w.Header().Set(upstreamDurationHeader, strconv.FormatInt(upstreamElapsed.Milliseconds(), 10))
+		platform.WriteError(w, r, http.StatusGatewayTimeout, "gateway_timeout", "gateway timeout")
+		return
+	}
+	fetch := func(loc location) {
+		if days > 0 {
+			current, forecast, err = fetchForecast(ctx, loc, days, opts)
+		} else {
+			current, err = fetchWeather(ctx, loc, opts)
+		}
+	}
+	upstreamStart = time.Now()
+	fetch(loc)
+	// Small municipalities are not always known to the weather provider;
+	// the state capital is the closest answer we can give.
+	if capital, ok := capitalLocation(loc); ok && errors.Is(err, errLocationNotFound) {
+		slog.WarnContext(ctx, "weather location not found, using state capital", "city", loc.City, "capital", capital.City)
+		span.SetAttributes(attribute.String("weather.fallback", "capital"))
+		fetch(capital)
+		if err == nil {
+			w.Header().Set(weatherFallbackHeader, "capital")
+		}
+	}
+	upstreamElapsed += time.Since(upstreamStart)
+	release()
+	w.Header().Set(upstreamDurationHeader, strconv.FormatInt(upstreamElapsed.Milliseconds(), 10))
+	if errors.Is(err, errLocationNotFound) {
+		platform.WriteError(w, r, http.StatusNotFound, "location_not_found", "can not find location")
+		return
+	}
+	if err != nil {
+		if res, ok := staleWeather(r, staleKey, err); ok {
+			writeWeather(w, r, cep, res, units, decimals, true)
+			return
+		}
+		if partialOnWeatherFailure && r.Context().Err() == nil {
+			slog.WarnContext(ctx, "weather lookup failed, answering with the location only", "error", err)
+			writeWeather(w, r, cep, weatherResult{loc: loc, weatherError: weatherErrorMessage(err)}, units, decimals, false)
+			return
+		}
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	res := weatherResult{loc: loc, current: current, forecast: forecast}
+	if serveStale {
+		lastGood.set(staleKey, res)
+	}
+	writeWeather(w, r, cep, res, units, decimals, false)
+}
+
+// writeWeather renders res in the format r accepts. A stale result is marked
+// with servedStaleHeader; neither it nor a partial one is cacheable.
+func writeWeather(w http.ResponseWriter, r *http.Request, cep string, res weatherResult, units tempUnits, decimals tempPrecision, stale bool) {
+	loc, current := res.loc, res.current
+	out := out{
+		CEP:          cep,
+		CEPFormatted: formatCEP(cep),
+		City:         loc.City,
+		State:        loc.UF,
+		Neighborhood: loc.Neighborhood,
+		Complement:   loc.Complement,
+
+		CEPProvider:     loc.Provider,
+		WeatherProvider: weatherProviderName,
+	}
+	if mockMode {
+		out.WeatherProvider = "mock"
+	}
+	if loc.hasCoordinates() {
+		out.Coordinates = &coordinatesOut{Lat: *loc.Lat, Lon: *loc.Lon}
+	}
+	if res.weatherError != "" {
+		out.WeatherError = res.weatherError
+	} else {
+		out.Humidity, out.WindKph = &current.Humidity, &current.WindKph
+		out.Condition = current.Condition.Text
+		out.TempC, out.TempF, out.TempK = units.temps(current.TempC, decimals)
+		if aq := current.AirQuality; aq != nil {
+			out.AirQuality = &airQualityOut{PM25: aq.PM25, PM10: aq.PM10}
+		}
+		for _, d := range res.forecast {
+			f := forecastOut{Date: d.Date}
+			f.MinC, f.MinF, f.MinK = units.temps(d.MinC, decimals)
+			f.MaxC, f.MaxF, f.MaxK = units.temps(d.MaxC, decimals)
+			out.Forecast = append(out.Forecast, f)
+		}
+	}
+
+	var body bytes.Buffer
+	contentType := "application/json"
+	if wantsXML(r.Header.Get("Accept")) {
+		contentType = "application/xml"
+		body.WriteString(xml.Header)
+		xml.NewEncoder(&body).Encode(out)
+	} else {
+		json.NewEncoder(&body).Encode(out)
+	}
+	b := body.Bytes()
+	if wantsCamel(r) && contentType == "application/json" {
+		// out is plain data, so its own encoding always parses.
+		b, _ = camelizeJSON(b)
+	}
+	if stale || res.weatherError != "" {
+		if stale {
+			w.Header().Set(servedStaleHeader, "true")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", contentType)
+		w.Write(b)
+		return
+	}
+	writeCacheable(w, r, contentType, b)
+}
+
+// writeCacheable writes a successful response with Cache-Control and an ETag
+// derived from body, answering 304 Not Modified when If-None-Match already
+// names that ETag.
+func writeCacheable(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Add("Vary", "Accept, "+outputStyleHeader)
+	if weatherCacheSeconds > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", weatherCacheSeconds))
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header value lists etag,
+// using the weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsXML reports whether the first XML or JSON media type listed in accept
+// is XML. Quality values are not weighed.
+func wantsXML(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.TrimSpace(strings.ToLower(mediaType)) {
+		case "application/xml", "text/xml":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// validateWeatherAPIKey rejects an empty key so a misconfigured deploy fails
+// at startup instead of on the first request.
+func validateWeatherAPIKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", errors.New("WEATHER_API_KEY is required")
+	}
+	return key, nil
+}
+
+// handleHealthz is a liveness probe. It is intentionally not traced.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// writeUpstreamError reports a failed upstream call: 504 when it ran out of
+// time, 503 with Retry-After when a provider is throttling us or our quota
+// is spent, 502 for anything else.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	if platform.ClientCanceled(r, err) {
+		return
+	}
+	var (
+		decodeErr    *upstreamDecodeError
+		throttledErr *throttledError
+		quotaErr     *quotaExceededError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		platform.WriteError(w, r, http.StatusGatewayTimeout, "gateway_timeout", "gateway timeout")
+	case errors.As(err, &throttledErr):
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(throttledErr.retryAfter.Seconds())), 1)))
+		platform.WriteError(w, r, http.StatusServiceUnavailable, "upstream_throttled", "upstream rate limited")
+	case errors.As(err, &quotaErr):
+		if quotaErr.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(quotaErr.retryAfter.Seconds())), 1)))
+		}
+		platform.WriteError(w, r, http.StatusServiceUnavailable, "upstream_quota_exceeded", "weather provider quota exceeded")
+	case errors.Is(err, platform.ErrUpstreamTooLarge):
+		platform.WriteError(w, r, http.StatusBadGateway, "upstream_too_large", "upstream response too large")
+	case errors.As(err, &decodeErr):
+		platform.WriteError(w, r, http.StatusBadGateway, "upstream_decode_error", "invalid upstream response")
+	default:
+		platform.WriteError(w, r, http.StatusBadGateway, "bad_gateway", "bad gateway")
+	}
+}
+
+// requestTimeout bounds the upstream calls for r: upstreamTimeout, shortened
+// to the caller's deadlineHeader less deadlineBuffer when that is sooner.
+func requestTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get(deadlineHeader)
+	if v == "" {
+		return upstreamTimeout
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 {
+		slog.DebugContext(r.Context(), "ignoring invalid deadline header", "value", v)
+		return upstreamTimeout
+	}
+	return min(upstreamTimeout, time.Duration(ms)*time.Millisecond-deadlineBuffer)
+}
+
+// timeLeft is how long remains until ctx's deadline.
+func timeLeft(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Until(deadline)
+}
+
+// baseURL trims trailing slashes so paths can be appended with a single "/".
+func baseURL(u string) string {
+	return strings.TrimRight(u, "/")
+}
+
+// formatCEP renders an 8-digit CEP in the usual "01001-000" form.
+func formatCEP(cep string) string {
+	if len(cep) != 8 {
+		return cep
+	}
+	return cep[:5] + "-" + cep[5:]
+}
+
+// allSameDigit reports whether cep repeats a single digit, like "00000000".
+// No real CEP does, so these are rejected without an upstream call.
+func allSameDigit(cep string) bool {
+	return cep != "" && strings.Count(cep, cep[:1]) == len(cep)
+}
+
+// parseDays reads the optional days parameter. An empty value means no
+// forecast and returns 0.
+func parseDays(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if days < 1 || days > maxForecastDays {
+		return 0, fmt.Errorf("days %d out of range", days)
+	}
+	return days, nil
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*1.8 + 32
+}
+
+func celsiusToKelvin(c float64) float64 {
+	return c + 273.15
+}
+
+func round1(v float64) float64 {
+	return roundN(v, 1)
+}
+
+// roundN rounds v to the given number of decimal places, half away from
+// zero, so negative values round symmetrically with positive ones. A result
+// of zero is always positive zero: encoding/json writes -0 as "-0".
+func roundN(v float64, decimals int) float64 {
+	p := math.Pow10(decimals)
+	r := math.Round(v*p) / p
+	if r == 0 {
+		return 0
+	}
+	return r
+}
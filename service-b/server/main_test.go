@@ -0,0 +1,1029 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"service-b/platform"
+)
+
+func TestRoundN(t *testing.T) {
+	tests := []struct {
+		v        float64
+		decimals int
+		want     float64
+	}{
+		{21.25, 1, 21.3},
+		{-21.25, 1, -21.3},
+		{21.24, 1, 21.2},
+		{0.04, 1, 0},
+		{-0.04, 1, 0},
+		{-0.4, 0, 0},
+		{-0.5, 0, -1},
+		{294.399, 2, 294.4},
+	}
+	for _, tt := range tests {
+		got := roundN(tt.v, tt.decimals)
+		if got != tt.want || math.Signbit(got) != math.Signbit(tt.want) {
+			t.Errorf("roundN(%v, %d) = %v, want %v", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestTempsNeverNegativeZero(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        float64
+		decimals tempPrecision
+	}{
+		{"celsius just below zero", -0.04, tempDecimals},
+		{"celsius int format", -0.4, tempPrecision{}},
+		{"fahrenheit near zero", -17.79, tempDecimals},
+		{"fahrenheit int format", -17.9, tempPrecision{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, tf, tk := allUnits.temps(tt.c, tt.decimals)
+			b, err := json.Marshal([]*float64{tc, tf, tk})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []*float64{tc, tf, tk} {
+				if *v == 0 && math.Signbit(*v) {
+					t.Errorf("temps(%v) = %s, has negative zero", tt.c, b)
+				}
+			}
+		})
+	}
+}
+
+// withViaCEP serves viacep lookups from h for the duration of the test, with
+// BrasilAPI unreachable and an empty CEP cache.
+func withViaCEP(t *testing.T, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	prevViaCEP, prevBrasilAPI, prevClient, prevCache := viaCEPBaseURL, brasilAPIBaseURL, httpClient, cepCache
+	viaCEPBaseURL, brasilAPIBaseURL = srv.URL, "http://127.0.0.1:1"
+	httpClient = platform.NewHTTPClient()
+	cepCache = newLocationCache(time.Hour, 0, 10000)
+	t.Cleanup(func() {
+		viaCEPBaseURL, brasilAPIBaseURL, httpClient, cepCache = prevViaCEP, prevBrasilAPI, prevClient, prevCache
+	})
+}
+
+// withMockMode serves canned locations and weather for the duration of the
+// test, like MOCK_MODE=true.
+func withMockMode(t *testing.T) {
+	t.Helper()
+	prevMock, prevWeather, prevForecast := mockMode, fetchWeather, fetchForecast
+	mockMode, fetchWeather, fetchForecast = true, fetchMockWeather, fetchMockForecast
+	t.Cleanup(func() { mockMode, fetchWeather, fetchForecast = prevMock, prevWeather, prevForecast })
+}
+
+// getWeather serves GET target through handleWeather.
+func getWeather(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleWeather(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// captureLogs sends the default logger's JSON records to the returned buffer
+// for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(platform.NewLogHandler(&buf, slog.LevelDebug)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// logRecords decodes the JSON records in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// errorResp is the JSON error envelope written by platform.WriteError.
+type errorResp struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// decodeError decodes the JSON error envelope in rec, failing the test when
+// the body is not one.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResp {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var e errorResp
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("body %q is not a JSON error: %v", rec.Body, err)
+	}
+	return e
+}
+
+func TestHandleWeatherErrors(t *testing.T) {
+	withMockMode(t)
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"/weather", http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"/weather?cep=123", http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"/weather?cep=0100100a", http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"/weather?cep=11111111", http.StatusUnprocessableEntity, "invalid_zipcode"},
+	}
+	for _, tt := range tests {
+		rec := getWeather(t, tt.target)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, rec.Code, tt.wantStatus)
+		}
+		if e := decodeError(t, rec); e.Error.Code != tt.wantCode || e.Error.Message == "" {
+			t.Errorf("GET %s: error = %+v, want code %q with a message", tt.target, e.Error, tt.wantCode)
+		}
+	}
+}
+
+func TestHandleWeatherZipcodeNotFound(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"erro":"true"}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "zipcode_not_found" {
+		t.Errorf("code = %q, want zipcode_not_found", e.Error.Code)
+	}
+}
+
+func TestTemperatureConversions(t *testing.T) {
+	tests := []struct {
+		c, wantF, wantK float64
+	}{
+		{0, 32, 273.15},
+		{100, 212, 373.15},
+		{-40, -40, 233.15},
+		{25, 77, 298.15},
+		{-273.15, -459.67, 0},
+	}
+	for _, tt := range tests {
+		if got := celsiusToFahrenheit(tt.c); math.Abs(got-tt.wantF) > 1e-9 {
+			t.Errorf("celsiusToFahrenheit(%v) = %v, want %v", tt.c, got, tt.wantF)
+		}
+		if got := celsiusToKelvin(tt.c); math.Abs(got-tt.wantK) > 1e-9 {
+			t.Errorf("celsiusToKelvin(%v) = %v, want %v", tt.c, got, tt.wantK)
+		}
+	}
+}
+
+func TestHandleWeatherKelvin(t *testing.T) {
+	withMockMode(t)
+	prev := tempDecimals
+	tempDecimals = tempPrecision{C: 1, F: 1, K: 2}
+	t.Cleanup(func() { tempDecimals = prev })
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	var got out
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// mockWeather is 25 °C.
+	if got.TempK == nil || *got.TempK != 298.15 {
+		t.Errorf("temp_K = %v, want 298.15", got.TempK)
+	}
+}
+
+func TestHandleWeatherClientCanceled(t *testing.T) {
+	sr := recordSpans(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	var fallbacks atomic.Int32
+	withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbacks.Add(1)
+	}))
+
+	ctx, span := otel.Tracer("test").Start(ctx, "GET /weather")
+	rec := httptest.NewRecorder()
+	handleWeather(rec, httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil).WithContext(ctx))
+	span.End()
+
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("wrote %q to a client that went away", rec.Body)
+	}
+	if n := fallbacks.Load(); n != 0 {
+		t.Errorf("made %d brasilapi calls after the client left, want 0", n)
+	}
+	spans := sr.Ended()
+	if got := spans[len(spans)-1].Status(); got.Code != codes.Error || got.Description != "client canceled request" {
+		t.Errorf("span status = %+v, want an error for the canceled request", got)
+	}
+}
+
+func TestHandleWeatherUpstreamTimeout(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	prev := upstreamTimeout
+	upstreamTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { upstreamTimeout = prev })
+
+	start := time.Now()
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "gateway_timeout" {
+		t.Errorf("code = %q, want gateway_timeout", e.Error.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, want about %v", elapsed, upstreamTimeout)
+	}
+}
+
+func TestHandleWeatherMinBudget(t *testing.T) {
+	prevTimeout, prevBudget := upstreamTimeout, weatherMinBudget
+	upstreamTimeout, weatherMinBudget = 400*time.Millisecond, 250*time.Millisecond
+	t.Cleanup(func() { upstreamTimeout, weatherMinBudget = prevTimeout, prevBudget })
+
+	tests := []struct {
+		name        string
+		viaCEPDelay time.Duration
+		wantStatus  int
+		wantWeather int32
+	}{
+		{"budget left", 0, http.StatusOK, 1},
+		{"viacep ate the budget", 250 * time.Millisecond, http.StatusGatewayTimeout, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.viaCEPDelay)
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			var weatherCalls atomic.Int32
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				weatherCalls.Add(1)
+				w.Write([]byte(`{"current":{"temp_c":20}}`))
+			}))
+
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if n := weatherCalls.Load(); n != tt.wantWeather {
+				t.Errorf("weather provider called %d times, want %d", n, tt.wantWeather)
+			}
+			if tt.wantStatus == http.StatusGatewayTimeout {
+				if e := decodeError(t, rec); e.Error.Code != "gateway_timeout" {
+					t.Errorf("code = %q, want gateway_timeout", e.Error.Code)
+				}
+				if rec.Header().Get(upstreamDurationHeader) == "" {
+					t.Errorf("%s not set on the short-circuit", upstreamDurationHeader)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"ok"}` {
+		t.Errorf("got %d %s, want 200 {\"status\":\"ok\"}", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+}
+
+func TestHandleWeatherState(t *testing.T) {
+	tests := []struct {
+		viaCEP    string
+		wantState string
+	}{
+		{`{"localidade":"Rio de Janeiro","uf":"RJ"}`, "RJ"},
+		{`{"localidade":"Rio de Janeiro"}`, ""},
+	}
+	for _, tt := range tests {
+		withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.viaCEP))
+		}))
+		withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"current":{"temp_c":30}}`))
+		}))
+
+		rec := getWeather(t, "/weather?cep=20040020")
+		var got map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		state, ok := got["state"]
+		if tt.wantState == "" && ok {
+			t.Errorf("viacep %s: state = %v, want it omitted", tt.viaCEP, state)
+		}
+		if tt.wantState != "" && state != tt.wantState {
+			t.Errorf("viacep %s: state = %v, want %s", tt.viaCEP, state, tt.wantState)
+		}
+	}
+}
+
+func TestRound1(t *testing.T) {
+	tests := []struct{ v, want float64 }{
+		{1.25, 1.3},
+		{-1.25, -1.3},
+		{-1.24, -1.2},
+		{-2.55, -2.6},
+		{-0.05, -0.1},
+		{-0.04, 0},
+	}
+	for _, tt := range tests {
+		if got := round1(tt.v); got != tt.want {
+			t.Errorf("round1(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherNegativeTemperature(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"Urupema","uf":"SC"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":-0.04}}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=88625000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), `"temp_C":-0`) {
+		t.Errorf("body = %s, has a negative zero", rec.Body)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://viacep.com.br/ws", "https://viacep.com.br/ws"},
+		{"https://viacep.com.br/ws/", "https://viacep.com.br/ws"},
+		{"http://proxy:8080//", "http://proxy:8080"},
+	}
+	for _, tt := range tests {
+		if got := baseURL(tt.in); got != tt.want {
+			t.Errorf("baseURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// withGlobalProviders restores the global OTel providers and propagator
+// after the test.
+func withGlobalProviders(t *testing.T) {
+	t.Helper()
+	tp, mp, prop := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+		otel.SetTextMapPropagator(prop)
+	})
+}
+
+// recordSpans installs a tracer provider that keeps ended spans in memory for
+// the duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	withGlobalProviders(t)
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	return sr
+}
+
+// spanAttrs returns the attributes of the ended span called name.
+func spanAttrs(t *testing.T, sr *tracetest.SpanRecorder, name string) map[attribute.Key]attribute.Value {
+	t.Helper()
+	for _, s := range sr.Ended() {
+		if s.Name() == name {
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, kv := range s.Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			return attrs
+		}
+	}
+	t.Fatalf("no span %q", name)
+	return nil
+}
+
+func TestHandleWeatherSpanAttributes(t *testing.T) {
+	sr := recordSpans(t)
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":20}}`))
+	}))
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "GET /weather")
+	rec := httptest.NewRecorder()
+	handleWeather(rec, httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil).WithContext(ctx))
+	span.End()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	want := map[attribute.Key]string{"cep.code": "01001000", "cep.city": "São Paulo", "cep.uf": "SP"}
+	for _, name := range []string{"GET /weather", "resolve location"} {
+		attrs := spanAttrs(t, sr, name)
+		for k, v := range want {
+			if got := attrs[k].AsString(); got != v {
+				t.Errorf("%s: %s = %q, want %q", name, k, got, v)
+			}
+		}
+	}
+	// The lookup's own span leaves the CEP to its parent and the HTTP span.
+	for k, v := range spanAttrs(t, sr, "viaCEP lookup") {
+		if strings.Contains(v.Emit(), "01001000") {
+			t.Errorf("viaCEP lookup: %s = %q repeats the CEP", k, v.Emit())
+		}
+	}
+}
+
+func TestWantsXML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/xml", true},
+		{"text/xml", true},
+		{"Application/XML; charset=utf-8", true},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", true},
+		{"application/json, application/xml", false},
+		{"application/xml;q=0.1, application/json", true},
+	}
+	for _, tt := range tests {
+		if got := wantsXML(tt.accept); got != tt.want {
+			t.Errorf("wantsXML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherXML(t *testing.T) {
+	withMockMode(t)
+	req := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+	req.Header.Set("Accept", "application/xml")
+	rec := httptest.NewRecorder()
+	handleWeather(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("Content-Type = %q, want application/xml", ct)
+	}
+	var got out
+	if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body %s is not XML: %v", rec.Body, err)
+	}
+	if got.XMLName.Local != "weather" || got.CEP != "01001000" || got.City != mockLocation.City {
+		t.Errorf("got %+v, want <weather> for 01001000", got)
+	}
+	if !strings.HasPrefix(rec.Body.String(), xml.Header) {
+		t.Errorf("body does not start with the XML declaration: %.40s", rec.Body)
+	}
+}
+
+func TestWriteUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, "gateway_timeout"},
+		{"wrapped deadline", fmt.Errorf("viacep: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "gateway_timeout"},
+		{"joined deadline", errors.Join(errors.New("viacep status 500"), context.DeadlineExceeded), http.StatusGatewayTimeout, "gateway_timeout"},
+		{"other failure", errors.New("connection refused"), http.StatusBadGateway, "bad_gateway"},
+		{"decode", &upstreamDecodeError{provider: "viacep", err: errors.New("invalid character '<'")}, http.StatusBadGateway, "upstream_decode_error"},
+		{"throttled", &throttledError{provider: "viacep", retryAfter: 7 * time.Second}, http.StatusServiceUnavailable, "upstream_throttled"},
+		{"joined throttled", errors.Join(&throttledError{provider: "viacep"}, errors.New("brasilapi status 500")), http.StatusServiceUnavailable, "upstream_throttled"},
+		{"joined decode", errors.Join(&upstreamDecodeError{provider: "viacep"}, errors.New("brasilapi status 500")), http.StatusBadGateway, "upstream_decode_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeUpstreamError(rec, httptest.NewRequest(http.MethodGet, "/weather", nil), tt.err)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", e.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherUpstreamDurationHeader(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"current":{"temp_c":20}}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	ms, err := strconv.Atoi(rec.Header().Get(upstreamDurationHeader))
+	if err != nil || ms < 40 {
+		t.Errorf("%s = %q, want at least the 40ms spent upstream", upstreamDurationHeader, rec.Header().Get(upstreamDurationHeader))
+	}
+
+	// A cached location still reports the weather call.
+	rec = getWeather(t, "/weather?cep=01001000")
+	if ms, err := strconv.Atoi(rec.Header().Get(upstreamDurationHeader)); err != nil || ms < 20 {
+		t.Errorf("cached location: %s = %q, want at least the 20ms of the weather call", upstreamDurationHeader, rec.Header().Get(upstreamDurationHeader))
+	}
+}
+
+func TestAllSameDigit(t *testing.T) {
+	tests := []struct {
+		cep  string
+		want bool
+	}{
+		{"00000000", true},
+		{"55555555", true},
+		{"01001000", false},
+		{"55555554", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := allSameDigit(tt.cep); got != tt.want {
+			t.Errorf("allSameDigit(%q) = %v, want %v", tt.cep, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCEP(t *testing.T) {
+	tests := []struct {
+		cep, want string
+	}{
+		{"01001000", "01001-000"},
+		{"69900970", "69900-970"},
+		{"12345", "12345"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := formatCEP(tt.cep); got != tt.want {
+			t.Errorf("formatCEP(%q) = %q, want %q", tt.cep, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherCEPFields(t *testing.T) {
+	withMockMode(t)
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got out
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.CEP != "01001000" || got.CEPFormatted != "01001-000" {
+		t.Errorf("cep = %q, cepFormatted = %q; want 01001000, 01001-000", got.CEP, got.CEPFormatted)
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1", 1, false},
+		{"2", 2, false},
+		{"3", 3, false},
+		{"0", 0, true},
+		{"4", 0, true},
+		{"-1", 0, true},
+		{"abc", 0, true},
+		{"2.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDays(tt.v)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseDays(%q) = %d, %v; want %d, error %v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandleWeatherDays(t *testing.T) {
+	withMockMode(t)
+	tests := []struct {
+		days       string
+		wantStatus int
+	}{
+		{"0", http.StatusUnprocessableEntity},
+		{"4", http.StatusUnprocessableEntity},
+		{"abc", http.StatusUnprocessableEntity},
+		{"2", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := getWeather(t, "/weather?cep=01001000&days="+tt.days)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("days=%s: status = %d, want %d", tt.days, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus == http.StatusOK {
+			continue
+		}
+		e := decodeError(t, rec)
+		if e.Error.Code != "invalid_days" || !strings.Contains(e.Error.Message, "between 1 and 3") {
+			t.Errorf("days=%s: error = %+v, want invalid_days stating the range", tt.days, e.Error)
+		}
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, etag, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherCachingHeaders(t *testing.T) {
+	withMockMode(t)
+	prev := weatherCacheSeconds
+	weatherCacheSeconds = 60
+	t.Cleanup(func() { weatherCacheSeconds = prev })
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag %q; want 200 with an ETag", rec.Code, etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q, want public, max-age=60", cc)
+	}
+	if again := getWeather(t, "/weather?cep=01001000"); again.Header().Get("ETag") != etag {
+		t.Errorf("ETag changed between identical responses: %q, %q", etag, again.Header().Get("ETag"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handleWeather(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("If-None-Match: status = %d with %d body bytes, want an empty 304", rec.Code, rec.Body.Len())
+	}
+	if rec.Header().Get("ETag") != etag {
+		t.Errorf("304 ETag = %q, want %q", rec.Header().Get("ETag"), etag)
+	}
+
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	handleWeather(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: status = %d, want 200", rec.Code)
+	}
+
+	rec = getWeather(t, "/weather?cep=123")
+	if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("error response has ETag %q, Cache-Control %q; want neither", rec.Header().Get("ETag"), rec.Header().Get("Cache-Control"))
+	}
+
+	weatherCacheSeconds = 0
+	if rec := getWeather(t, "/weather?cep=01001000"); rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("WEATHER_CACHE_SECONDS=0: Cache-Control = %q, want none", rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestHandleWeatherUpstreamTooLarge(t *testing.T) {
+	prev := platform.MaxUpstreamBytes
+	platform.MaxUpstreamBytes = 256
+	t.Cleanup(func() { platform.MaxUpstreamBytes = prev })
+
+	tests := []struct {
+		name     string
+		viaCEP   string
+		weather  string
+		wantCode int
+	}{
+		{
+			name:     "within the limit",
+			viaCEP:   `{"localidade":"São Paulo","uf":"SP"}`,
+			weather:  `{"current":{"temp_c":28.5}}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "viacep over the limit",
+			viaCEP:   `{"localidade":"São Paulo","uf":"SP","bairro":"` + strings.Repeat("x", 256) + `"}`,
+			weather:  `{"current":{"temp_c":28.5}}`,
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			name:     "weatherapi over the limit",
+			viaCEP:   `{"localidade":"São Paulo","uf":"SP"}`,
+			weather:  `{"current":{"temp_c":28.5,"condition":{"text":"` + strings.Repeat("x", 256) + `"}}}`,
+			wantCode: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.viaCEP))
+			}))
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.weather))
+			}))
+
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusBadGateway {
+				if e := decodeError(t, rec); e.Error.Code != "upstream_too_large" {
+					t.Errorf("code = %q, want upstream_too_large", e.Error.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleWeatherNeighborhoodAndComplement(t *testing.T) {
+	tests := []struct {
+		name             string
+		viaCEPStatus     int
+		viaCEP           string
+		brasilAPI        string
+		wantNeighborhood string
+		wantComplement   string
+	}{
+		{
+			name:             "viacep with both",
+			viaCEPStatus:     http.StatusOK,
+			viaCEP:           `{"localidade":"São Paulo","uf":"SP","bairro":"Sé","complemento":"lado ímpar"}`,
+			wantNeighborhood: "Sé",
+			wantComplement:   "lado ímpar",
+		},
+		{
+			name:         "viacep with neither",
+			viaCEPStatus: http.StatusOK,
+			viaCEP:       `{"localidade":"São Paulo","uf":"SP","bairro":"","complemento":""}`,
+		},
+		{
+			name:             "brasilapi has no complement",
+			viaCEPStatus:     http.StatusBadGateway,
+			brasilAPI:        `{"city":"São Paulo","state":"SP","neighborhood":"Sé"}`,
+			wantNeighborhood: "Sé",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.viaCEPStatus)
+				w.Write([]byte(tt.viaCEP))
+			}))
+			withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.brasilAPI))
+			}))
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"current":{"temp_c":20}}`))
+			}))
+
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range map[string]string{"neighborhood": tt.wantNeighborhood, "complement": tt.wantComplement} {
+				v, ok := got[key]
+				if want == "" {
+					if ok {
+						t.Errorf("%s = %v, want it omitted", key, v)
+					}
+				} else if v != want {
+					t.Errorf("%s = %v, want %q", key, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleWeatherPOST(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.5}}`))
+	}))
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"cep in the body", "/weather", `{"cep":"01001000"}`, http.StatusOK, ""},
+		{"body wins over the query", "/weather?cep=123", `{"cep":"01001000"}`, http.StatusOK, ""},
+		{"query options still apply", "/weather?units=c", `{"cep":"01001000"}`, http.StatusOK, ""},
+		{"invalid cep in the body", "/weather", `{"cep":"123"}`, http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"missing cep", "/weather", `{}`, http.StatusUnprocessableEntity, "invalid_zipcode"},
+		{"not json", "/weather", `cep=01001000`, http.StatusBadRequest, "invalid_request"},
+		{"empty body", "/weather", ``, http.StatusBadRequest, "invalid_request"},
+		{"body too large", "/weather", `{"cep":"01001000","pad":"` + strings.Repeat("x", maxRequestBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, "body_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleWeather(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+					t.Errorf("code = %q, want %s", e.Error.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleWeatherCoordinates(t *testing.T) {
+	tests := []struct {
+		name         string
+		viaCEPStatus int
+		brasilAPI    string
+		accept       string
+		want         string
+		wantNone     bool
+	}{
+		{
+			name:         "brasilapi json",
+			viaCEPStatus: http.StatusBadGateway,
+			brasilAPI:    `{"city":"São Paulo","state":"SP","location":{"coordinates":{"latitude":"-23.5505","longitude":"-46.6333"}}}`,
+			want:         `"coordinates":{"lat":-23.5505,"lon":-46.6333}`,
+		},
+		{
+			name:         "brasilapi xml",
+			viaCEPStatus: http.StatusBadGateway,
+			brasilAPI:    `{"city":"São Paulo","state":"SP","location":{"coordinates":{"latitude":"-23.5505","longitude":"-46.6333"}}}`,
+			accept:       "application/xml",
+			want:         `<coordinates><lat>-23.5505</lat><lon>-46.6333</lon></coordinates>`,
+		},
+		{
+			name:         "brasilapi without coordinates",
+			viaCEPStatus: http.StatusBadGateway,
+			brasilAPI:    `{"city":"São Paulo","state":"SP","location":{"coordinates":{}}}`,
+			wantNone:     true,
+		},
+		{
+			name:         "viacep has none",
+			viaCEPStatus: http.StatusOK,
+			wantNone:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.viaCEPStatus)
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			withBrasilAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.brasilAPI))
+			}))
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"current":{"temp_c":21.5}}`))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handleWeather(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if tt.wantNone {
+				if strings.Contains(rec.Body.String(), "coordinates") {
+					t.Errorf("body = %s, want no coordinates", rec.Body)
+				}
+			} else if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	prev := upstreamTimeout
+	upstreamTimeout = 2 * time.Second
+	t.Cleanup(func() { upstreamTimeout = prev })
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 2 * time.Second},
+		{"1000", 950 * time.Millisecond},
+		{"5000", 2 * time.Second},
+		{"2050", 2 * time.Second},
+		{"30", -20 * time.Millisecond},
+		{"0", -deadlineBuffer},
+		{"-5", 2 * time.Second},
+		{"soon", 2 * time.Second},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+		if tt.header != "" {
+			r.Header.Set(deadlineHeader, tt.header)
+		}
+		if got := requestTimeout(r); got != tt.want {
+			t.Errorf("%s=%q: requestTimeout = %v, want %v", deadlineHeader, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherHonorsDeadlineHeader(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	prev := upstreamTimeout
+	upstreamTimeout = 10 * time.Second
+	t.Cleanup(func() { upstreamTimeout = prev })
+
+	req := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+	req.Header.Set(deadlineHeader, "150")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	handleWeather(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handler took %v with %s=150, want it to give up near 100ms", elapsed, deadlineHeader)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", rec.Code, rec.Body)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "gateway_timeout" {
+		t.Errorf("code = %q, want gateway_timeout", e.Error.Code)
+	}
+}
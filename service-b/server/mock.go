@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// mockMode makes service-b answer every valid CEP with canned data instead
+// of calling the upstream providers; set from MOCK_MODE.
+var mockMode bool
+
+var mockLocation = location{City: "TestCity", UF: "TS", Provider: "mock"}
+
+var mockWeather = weatherCurrent{TempC: 25.0, Humidity: 50, WindKph: 10.0}
+
+var mockAirQuality = airQuality{PM25: 12.5, PM10: 20.0}
+
+func mockCurrent(opts weatherOptions) weatherCurrent {
+	current := mockWeather
+	if opts.AQI {
+		aq := mockAirQuality
+		current.AirQuality = &aq
+	}
+	return current
+}
+
+// fetchMockForecast returns a flat canned forecast starting today.
+func fetchMockForecast(ctx context.Context, loc location, days int, opts weatherOptions) (weatherCurrent, []forecastDay, error) {
+	_, span := otel.Tracer("service-b").Start(ctx, "mock weather forecast")
+	defer span.End()
+	span.SetAttributes(attribute.Bool("mock", true), weatherQueryAttr(loc), attribute.Int("weather.days", days))
+
+	today := time.Now()
+	forecast := make([]forecastDay, days)
+	for i := range forecast {
+		forecast[i] = forecastDay{Date: today.AddDate(0, 0, i).Format("2006-01-02"), MinC: 18.0, MaxC: 28.0}
+	}
+	return mockCurrent(opts), forecast, nil
+}
+
+// fetchMockWeather stands in for a weather provider in mock mode. It still
+// creates a span so tracing can be exercised offline.
+func fetchMockWeather(ctx context.Context, loc location, opts weatherOptions) (weatherCurrent, error) {
+	_, span := otel.Tracer("service-b").Start(ctx, "mock weather current")
+	defer span.End()
+	span.SetAttributes(attribute.Bool("mock", true), weatherQueryAttr(loc))
+	return mockCurrent(opts), nil
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHandleWeatherMockMode(t *testing.T) {
+	withMockMode(t)
+	prev := viaCEPBaseURL
+	viaCEPBaseURL = "http://127.0.0.1:1"
+	t.Cleanup(func() { viaCEPBaseURL = prev })
+
+	rec := getWeather(t, "/weather?cep=01001000&days=2&aqi=true")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got out
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.City != mockLocation.City || got.CEPProvider != "mock" || got.WeatherProvider != "mock" {
+		t.Errorf("location = %s from %s/%s, want %s from mock/mock", got.City, got.CEPProvider, got.WeatherProvider, mockLocation.City)
+	}
+	if got.TempC == nil || *got.TempC != mockWeather.TempC {
+		t.Errorf("temp_C = %v, want %v", got.TempC, mockWeather.TempC)
+	}
+	if got.AirQuality == nil || got.AirQuality.PM25 != mockAirQuality.PM25 {
+		t.Errorf("air quality = %+v, want the mock values", got.AirQuality)
+	}
+	if len(got.Forecast) != 2 {
+		t.Errorf("got %d forecast days, want 2", len(got.Forecast))
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"service-b/platform"
+)
+
+// maxMultiCEPs bounds how many comma-separated CEPs one /weather GET may ask
+// for.
+const maxMultiCEPs = 10
+
+// multiItem is one CEP's answer in a multi-CEP /weather response: the usual
+// body in Result on success, or the usual error object in Error.
+type multiItem struct {
+	CEP    string          `json:"cep"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in
+// memory, so a single-CEP answer can be embedded in a multi-CEP one.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// isMultiCEP reports whether r asks /weather for several CEPs at once.
+func isMultiCEP(r *http.Request) bool {
+	return r.Method != http.MethodPost && strings.Contains(r.URL.Query().Get("cep"), ",")
+}
+
+// handleMultiWeather answers GET /weather?cep=A,B,C with a JSON array holding
+// each CEP's result in input order. Every CEP is looked up concurrently
+// through handleWeather with the request's other parameters, so one invalid
+// or failing CEP only fails its own item.
+func handleMultiWeather(w http.ResponseWriter, r *http.Request) {
+	ceps := strings.Split(r.URL.Query().Get("cep"), ",")
+	if len(ceps) > maxMultiCEPs {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "too_many_ceps", fmt.Sprintf("at most %d ceps per request", maxMultiCEPs))
+		return
+	}
+
+	items := make([]multiItem, len(ceps))
+	var wg sync.WaitGroup
+	for i, cep := range ceps {
+		cep = strings.TrimSpace(cep)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, span := otel.Tracer("service-b").Start(r.Context(), "weather item")
+			defer span.End()
+			span.SetAttributes(attribute.Int("multi.index", i))
+
+			sub := r.Clone(ctx)
+			q := sub.URL.Query()
+			q.Set("cep", cep)
+			sub.URL.RawQuery = q.Encode()
+			// Items are always JSON and never 304: the array is what the
+			// client caches, not its parts.
+			sub.Header.Set("Accept", "application/json")
+			sub.Header.Del("If-None-Match")
+
+			rec := &bufferedResponse{header: make(http.Header)}
+			platform.RecoverPanics(http.HandlerFunc(handleWeather)).ServeHTTP(rec, sub)
+			items[i] = multiItem{CEP: cep, Status: rec.status}
+			if rec.status == http.StatusOK {
+				items[i].Result = bytes.TrimSpace(rec.body.Bytes())
+				return
+			}
+			var e struct {
+				Error json.RawMessage `json:"error"`
+			}
+			json.Unmarshal(rec.body.Bytes(), &e)
+			items[i].Error = e.Error
+		}()
+	}
+	wg.Wait()
+
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(items)
+	for _, item := range items {
+		if item.Status != http.StatusOK {
+			// A failed item may well succeed on the next try.
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body.Bytes())
+			return
+		}
+	}
+	writeCacheable(w, r, "application/json", body.Bytes())
+}
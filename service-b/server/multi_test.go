@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsMultiCEP(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           bool
+	}{
+		{http.MethodGet, "/weather?cep=01001000", false},
+		{http.MethodGet, "/weather?cep=01001000,20040002", true},
+		{http.MethodGet, "/weather?cep=01001000%2C20040002", true},
+		{http.MethodHead, "/weather?cep=01001000,20040002", true},
+		{http.MethodPost, "/weather?cep=01001000,20040002", false},
+		{http.MethodGet, "/weather", false},
+	}
+	for _, tt := range tests {
+		if got := isMultiCEP(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.want {
+			t.Errorf("%s %s: isMultiCEP = %v, want %v", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMultiWeather(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "20040002"):
+			w.Write([]byte(`{"localidade":"Rio de Janeiro","uf":"RJ"}`))
+		case strings.Contains(r.URL.Path, "12345678"):
+			w.Write([]byte(`{"erro":"true"}`))
+		default:
+			w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+		}
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.5}}`))
+	}))
+
+	type item struct {
+		CEP    string `json:"cep"`
+		Status int    `json:"status"`
+		Result *struct {
+			City  string   `json:"city"`
+			TempC *float64 `json:"temp_C"`
+			TempF *float64 `json:"temp_F"`
+		} `json:"result"`
+		Error *struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	tests := []struct {
+		name         string
+		query        string
+		wantCities   []string
+		wantCodes    []string
+		wantCacheCtl string
+		wantOnlyC    bool
+	}{
+		{
+			name:       "all found, in input order",
+			query:      "cep=20040002,01001000",
+			wantCities: []string{"Rio de Janeiro", "São Paulo"},
+			wantCodes:  []string{"", ""},
+		},
+		{
+			name:       "spaces are trimmed",
+			query:      "cep=01001000,%2020040002",
+			wantCities: []string{"São Paulo", "Rio de Janeiro"},
+			wantCodes:  []string{"", ""},
+		},
+		{
+			name:         "failures stay in their own item",
+			query:        "cep=01001000,123,12345678",
+			wantCities:   []string{"São Paulo", "", ""},
+			wantCodes:    []string{"", "invalid_zipcode", "zipcode_not_found"},
+			wantCacheCtl: "no-cache",
+		},
+		{
+			name:       "other parameters apply to every item",
+			query:      "cep=01001000,20040002&units=c",
+			wantCities: []string{"São Paulo", "Rio de Janeiro"},
+			wantCodes:  []string{"", ""},
+			wantOnlyC:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getWeather(t, "/weather?"+tt.query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var items []item
+			if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+				t.Fatalf("body %s: %v", rec.Body, err)
+			}
+			if len(items) != len(tt.wantCities) {
+				t.Fatalf("got %d items, want %d", len(items), len(tt.wantCities))
+			}
+			for i, it := range items {
+				if tt.wantCodes[i] != "" {
+					if it.Error == nil || it.Error.Code != tt.wantCodes[i] || it.Result != nil {
+						t.Errorf("item %d = %+v, want error %s", i, it, tt.wantCodes[i])
+					}
+					continue
+				}
+				if it.Status != http.StatusOK || it.Result == nil || it.Result.City != tt.wantCities[i] {
+					t.Errorf("item %d = %+v, want %s", i, it, tt.wantCities[i])
+					continue
+				}
+				if tt.wantOnlyC && (it.Result.TempC == nil || it.Result.TempF != nil) {
+					t.Errorf("item %d with units=c: temp_C %v, temp_F %v; want only temp_C", i, it.Result.TempC, it.Result.TempF)
+				}
+			}
+			if tt.wantCacheCtl != "" {
+				if cc := rec.Header().Get("Cache-Control"); cc != tt.wantCacheCtl {
+					t.Errorf("Cache-Control = %q, want %q", cc, tt.wantCacheCtl)
+				}
+			} else if rec.Header().Get("ETag") == "" {
+				t.Error("all-success response has no ETag")
+			}
+		})
+	}
+}
+
+func TestHandleMultiWeatherTooMany(t *testing.T) {
+	ceps := strings.Repeat("01001000,", maxMultiCEPs) + "01001000"
+	rec := getWeather(t, "/weather?cep="+ceps)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "too_many_ceps" {
+		t.Errorf("code = %q, want too_many_ceps", e.Error.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryBaseDelay is the backoff before the first retry; it doubles on each
+// subsequent attempt and is jittered to avoid synchronized retries.
+const retryBaseDelay = 100 * time.Millisecond
+
+// retryClock times the waits between retries and reads Retry-After dates.
+var retryClock clock = realClock{}
+
+// doWithRetry sends req through httpClient, retrying up to upstreamMaxRetries
+// times on network errors, 429 and 5xx responses. Other 4xx responses are
+// returned immediately. A Retry-After on the response replaces the backoff;
+// if it would outlast the request deadline, the response is returned as is.
+// Waiting between attempts honors the request context, so retries never
+// outlive its deadline. The number of requests sent is recorded as
+// upstream.attempts on the caller's span, which is per provider.
+func doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := 0
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("upstream.attempts", attempts))
+	}()
+	backoff := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		attempts++
+		resp, err := httpClient.Do(req.Clone(ctx))
+		if attempt >= upstreamMaxRetries || !shouldRetry(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := backoff/2 + jitter(backoff/2)
+		if wait, ok := retryAfter(resp); ok {
+			// The deadline is kept by the context's own timer, so it is
+			// compared with the real time left rather than retryClock.
+			if timeLeft(ctx) < wait {
+				return resp, err
+			}
+			delay = wait
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-retryClock.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// retryAfter parses the Retry-After header of a 429 or 503 response, in
+// either its seconds or HTTP-date form.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter parses a Retry-After value in either its seconds or
+// HTTP-date form.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(retryClock.Now()), 0), true
+	}
+	return 0, false
+}
+
+// throttledError means a provider is still rate limiting us after retries.
+type throttledError struct {
+	provider   string
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %s", e.provider, e.retryAfter)
+}
+
+// quotaExceededError means a provider refused the call because our plan's
+// quota is used up. retryAfter is 0 when the provider did not say when it
+// resets.
+type quotaExceededError struct {
+	provider   string
+	retryAfter time.Duration
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded", e.provider)
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
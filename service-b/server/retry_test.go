@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	"service-b/platform"
+)
+
+func TestDoWithRetryBackoff(t *testing.T) {
+	clk := withFakeTime(t)
+	httpClient = platform.NewHTTPClient()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("status %d after %d calls, want 200 after 3", resp.StatusCode, calls.Load())
+	}
+	want := []time.Duration{retryBaseDelay - 1, 2*retryBaseDelay - 1}
+	if len(clk.waits) != len(want) || clk.waits[0] != want[0] || clk.waits[1] != want[1] {
+		t.Errorf("waits = %v, want %v", clk.waits, want)
+	}
+}
+
+func TestDoWithRetryAfterPastDeadline(t *testing.T) {
+	clk := withFakeTime(t)
+	httpClient = platform.NewHTTPClient()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("status %d after %d calls, want the 429 after 1", resp.StatusCode, calls.Load())
+	}
+	if len(clk.waits) != 0 {
+		t.Errorf("waited %v past the deadline", clk.waits)
+	}
+}
+
+func TestDoWithRetryStatuses(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantCalls int32
+	}{
+		{http.StatusOK, 1},
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusInternalServerError, 3},
+		{http.StatusServiceUnavailable, 3},
+	}
+	for _, tt := range tests {
+		withFakeTime(t)
+		httpClient = platform.NewHTTPClient()
+
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(tt.status)
+		}))
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := doWithRetry(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if resp.StatusCode != tt.status || calls.Load() != tt.wantCalls {
+			t.Errorf("status %d: got %d after %d calls, want %d calls", tt.status, resp.StatusCode, calls.Load(), tt.wantCalls)
+		}
+	}
+}
+
+func TestDoWithRetryNetworkError(t *testing.T) {
+	clk := withFakeTime(t)
+	httpClient = platform.NewHTTPClient()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:1", nil)
+	if _, err := doWithRetry(req); err == nil {
+		t.Fatal("doWithRetry to a closed port succeeded")
+	}
+	if len(clk.waits) != upstreamMaxRetries {
+		t.Errorf("retried %d times, want %d", len(clk.waits), upstreamMaxRetries)
+	}
+}
+
+func TestDoWithRetryRecordsAttempts(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int64
+	}{
+		{"first try", []int{http.StatusOK}, 1},
+		{"not retried", []int{http.StatusNotFound}, 1},
+		{"one retry", []int{http.StatusBadGateway, http.StatusOK}, 2},
+		{"retries exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := recordSpans(t)
+			withFakeTime(t)
+			prevClient := httpClient
+			httpClient = platform.NewHTTPClient()
+			t.Cleanup(func() { httpClient = prevClient })
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[min(int(calls.Add(1))-1, len(tt.statuses)-1)])
+			}))
+			defer srv.Close()
+
+			ctx, span := otel.Tracer("test").Start(context.Background(), "provider")
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			resp, err := doWithRetry(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			span.End()
+
+			if got := spanAttrs(t, sr, "provider")["upstream.attempts"].AsInt64(); got != tt.wantAttempts {
+				t.Errorf("upstream.attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherUpstreamAttempts(t *testing.T) {
+	sr := recordSpans(t)
+	withFakeTime(t)
+	var viaCEPCalls atomic.Int32
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if viaCEPCalls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.5}}`))
+	}))
+
+	if rec := getWeather(t, "/weather?cep=01001000"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	for name, want := range map[string]int64{"viaCEP lookup": 3, "weatherapi current": 1} {
+		if got := spanAttrs(t, sr, name)["upstream.attempts"].AsInt64(); got != want {
+			t.Errorf("%s: upstream.attempts = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	clk := withFakeTime(t)
+	tests := []struct {
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"0", 0, true},
+		{"5", 5 * time.Second, true},
+		{clk.Now().Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{clk.Now().Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.v)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.v, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDoWithRetryHonorsRetryAfter(t *testing.T) {
+	clk := withFakeTime(t)
+	httpClient = platform.NewHTTPClient()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("status %d after %d calls, want 200 after 2", resp.StatusCode, calls.Load())
+	}
+	if len(clk.waits) != 1 || clk.waits[0] != 2*time.Second {
+		t.Errorf("waits = %v, want the 2s Retry-After", clk.waits)
+	}
+}
+
+func TestHandleWeatherViaCEPThrottled(t *testing.T) {
+	withFakeTime(t)
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Errorf("Retry-After = %q, want 7", got)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "upstream_throttled" {
+		t.Errorf("code = %q, want upstream_throttled", e.Error.Code)
+	}
+}
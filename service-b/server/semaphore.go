@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"errors"
+)
+
+// upstreamSlots bounds concurrent weather provider calls; nil means no
+// limit. Sized from MAX_CONCURRENT_UPSTREAM.
+var upstreamSlots chan struct{}
+
+// errUpstreamSaturated means no upstream slot freed up before the request
+// deadline.
+var errUpstreamSaturated = errors.New("too many concurrent upstream calls")
+
+// acquireUpstream waits for a free upstream slot until ctx is done. The
+// returned release must be called once the upstream call finishes. If ctx is
+// canceled rather than timed out, its error is returned instead of
+// errUpstreamSaturated.
+func acquireUpstream(ctx context.Context) (release func(), err error) {
+	if upstreamSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case upstreamSlots <- struct{}{}:
+		return func() { <-upstreamSlots }, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
+		}
+		return nil, errUpstreamSaturated
+	}
+}
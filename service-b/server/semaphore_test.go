@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withUpstreamSlots limits upstream calls to n for the duration of the test;
+// n == 0 removes the limit.
+func withUpstreamSlots(t *testing.T, n int) {
+	t.Helper()
+	prev := upstreamSlots
+	upstreamSlots = nil
+	if n > 0 {
+		upstreamSlots = make(chan struct{}, n)
+	}
+	t.Cleanup(func() { upstreamSlots = prev })
+}
+
+func TestAcquireUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		slots   int
+		held    int
+		cancel  bool
+		wantErr error
+	}{
+		{"unlimited", 0, 0, false, nil},
+		{"free slot", 2, 1, false, nil},
+		{"saturated", 1, 1, false, errUpstreamSaturated},
+		{"canceled while waiting", 1, 1, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withUpstreamSlots(t, tt.slots)
+			for i := 0; i < tt.held; i++ {
+				upstreamSlots <- struct{}{}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			release, err := acquireUpstream(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(upstreamSlots) != min(tt.held+1, cap(upstreamSlots)) {
+				t.Errorf("%d slots held after acquire, want %d", len(upstreamSlots), tt.held+1)
+			}
+			release()
+			if len(upstreamSlots) != tt.held {
+				t.Errorf("%d slots held after release, want %d", len(upstreamSlots), tt.held)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherUpstreamSaturated(t *testing.T) {
+	withMockMode(t)
+	withUpstreamSlots(t, 1)
+	prevTimeout := upstreamTimeout
+	upstreamTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { upstreamTimeout = prevTimeout })
+
+	upstreamSlots <- struct{}{}
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "upstream_saturated" {
+		t.Errorf("code = %q, want upstream_saturated", e.Error.Code)
+	}
+
+	// Once the slot is free the same request goes through and gives it back.
+	<-upstreamSlots
+	upstreamTimeout = prevTimeout
+	if rec := getWeather(t, "/weather?cep=01001000"); rec.Code != http.StatusOK {
+		t.Fatalf("status with a free slot = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if len(upstreamSlots) != 0 {
+		t.Errorf("%d slots still held after the request", len(upstreamSlots))
+	}
+}
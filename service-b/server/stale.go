@@ -0,0 +1,136 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// servedStaleHeader marks a response built from the last good weather after
+// an upstream failure.
+const servedStaleHeader = "X-Served-Stale"
+
+var (
+	// serveStale answers upstream failures with the last good weather for
+	// the same request, when there is one; set from DEGRADED_SERVE_STALE.
+	serveStale bool
+
+	// partialOnWeatherFailure answers a failed weather lookup for a known
+	// CEP with the location alone and a weatherError; set from
+	// PARTIAL_ON_WEATHER_FAILURE. A stale answer is preferred when there is
+	// one.
+	partialOnWeatherFailure bool
+
+	lastGood = newLastGoodStore(10000, 6*time.Hour)
+)
+
+// weatherResult is the upstream data a /weather response is built from.
+type weatherResult struct {
+	loc      location
+	current  weatherCurrent
+	forecast []forecastDay
+
+	// weatherError is set when only loc is known.
+	weatherError string
+}
+
+// lastGoodStore keeps the latest successful weatherResult per CEP and
+// upstream options, up to maxEntries of them; the least recently used entry
+// is evicted first. Entries older than maxAge are too old to serve, even in
+// place of an error.
+type lastGoodStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxAge     time.Duration
+	clock      clock
+	order      *list.List // of *lastGoodEntry, most recently used first
+	entries    map[string]*list.Element
+}
+
+type lastGoodEntry struct {
+	key    string
+	res    weatherResult
+	stored time.Time
+}
+
+func newLastGoodStore(maxEntries int, maxAge time.Duration) *lastGoodStore {
+	return &lastGoodStore{
+		maxEntries: max(maxEntries, 1),
+		maxAge:     maxAge,
+		clock:      realClock{},
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// lastGoodKey identifies the upstream data a request needs; units and format
+// only change how it is rendered.
+func lastGoodKey(cep string, days int, opts weatherOptions) string {
+	return fmt.Sprintf("%s|%d|%t|%s", cep, days, opts.AQI, opts.Lang)
+}
+
+func (s *lastGoodStore) get(key string) (weatherResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return weatherResult{}, false
+	}
+	e := el.Value.(*lastGoodEntry)
+	if s.clock.Now().Sub(e.stored) > s.maxAge {
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return weatherResult{}, false
+	}
+	s.order.MoveToFront(el)
+	return e.res, true
+}
+
+func (s *lastGoodStore) set(key string, res weatherResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*lastGoodEntry)
+		e.res, e.stored = res, now
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&lastGoodEntry{key: key, res: res, stored: now})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lastGoodEntry).key)
+	}
+}
+
+// weatherErrorMessage describes a weather lookup failure for clients without
+// exposing upstream details.
+func weatherErrorMessage(err error) string {
+	var quota *quotaExceededError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "weather provider timed out"
+	case errors.As(err, &quota):
+		return "weather provider quota exceeded"
+	}
+	return "weather provider unavailable"
+}
+
+// staleWeather returns the last good result for key when serveStale is on
+// and the failure err is not the client going away.
+func staleWeather(r *http.Request, key string, err error) (weatherResult, bool) {
+	if !serveStale || r.Context().Err() != nil {
+		return weatherResult{}, false
+	}
+	res, ok := lastGood.get(key)
+	if ok {
+		slog.WarnContext(r.Context(), "upstream failed, serving stale weather", "error", err)
+	}
+	return res, ok
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLastGoodStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := newLastGoodStore(2, time.Hour)
+	s.clock = newFakeClock()
+
+	s.set("a", weatherResult{loc: location{City: "A"}})
+	s.set("b", weatherResult{loc: location{City: "B"}})
+	s.get("a") // b is now the least recently used
+	s.set("c", weatherResult{loc: location{City: "C"}})
+
+	if _, ok := s.get("b"); ok {
+		t.Error("b was kept over the size cap")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := s.get(k); !ok {
+			t.Errorf("%s was evicted", k)
+		}
+	}
+}
+
+func TestLastGoodStoreMaxAge(t *testing.T) {
+	clk := newFakeClock()
+	s := newLastGoodStore(10, time.Hour)
+	s.clock = clk
+
+	s.set("a", weatherResult{loc: location{City: "A"}})
+	clk.Advance(time.Hour)
+	if _, ok := s.get("a"); !ok {
+		t.Fatal("entry at max age was not served")
+	}
+	clk.Advance(time.Nanosecond)
+	if _, ok := s.get("a"); ok {
+		t.Fatal("entry older than max age was served")
+	}
+
+	// Storing again restarts the age.
+	s.set("a", weatherResult{loc: location{City: "A"}})
+	clk.Advance(30 * time.Minute)
+	s.set("a", weatherResult{loc: location{City: "A2"}})
+	clk.Advance(45 * time.Minute)
+	if res, ok := s.get("a"); !ok || res.loc.City != "A2" {
+		t.Fatalf("get = %+v, %v; want the refreshed entry", res.loc, ok)
+	}
+}
+
+func TestHandleWeatherServesStale(t *testing.T) {
+	tests := []struct {
+		name       string
+		serveStale bool
+		firstQuery string
+		wantStatus int
+		wantStale  bool
+	}{
+		{"serves the last good weather", true, "", http.StatusOK, true},
+		{"off by default", false, "", http.StatusBadGateway, false},
+		{"only for the same upstream options", true, "&days=2", http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			var failing atomic.Bool
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing.Load() {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte(`{"current":{"temp_c":21.5},"forecast":{"forecastday":[]}}`))
+			}))
+			prevServe, prevStore := serveStale, lastGood
+			serveStale, lastGood = tt.serveStale, newLastGoodStore(10, time.Hour)
+			t.Cleanup(func() { serveStale, lastGood = prevServe, prevStore })
+
+			if rec := getWeather(t, "/weather?cep=01001000"+tt.firstQuery); rec.Code != http.StatusOK {
+				t.Fatalf("first request: status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			failing.Store(true)
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get(servedStaleHeader) == "true"; got != tt.wantStale {
+				t.Errorf("%s = %q, want stale %v", servedStaleHeader, rec.Header().Get(servedStaleHeader), tt.wantStale)
+			}
+			if tt.wantStale {
+				if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "no-cache" {
+					t.Errorf("stale response has ETag %q, Cache-Control %q; want no ETag and no-cache",
+						rec.Header().Get("ETag"), rec.Header().Get("Cache-Control"))
+				}
+				if !strings.Contains(rec.Body.String(), `"temp_C":21.5`) {
+					t.Errorf("body = %s, want the last good temperature", rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestWeatherErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.DeadlineExceeded, "weather provider timed out"},
+		{fmt.Errorf("get weather: %w", context.DeadlineExceeded), "weather provider timed out"},
+		{&quotaExceededError{provider: "weatherapi"}, "weather provider quota exceeded"},
+		{errors.New("weather status 500: boom"), "weather provider unavailable"},
+	}
+	for _, tt := range tests {
+		if got := weatherErrorMessage(tt.err); got != tt.want {
+			t.Errorf("weatherErrorMessage(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWeatherPartialOnWeatherFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		partial          bool
+		stale            bool
+		weatherStatus    int
+		weatherBody      string
+		wantStatus       int
+		wantWeatherError string
+	}{
+		{
+			name:             "location with weatherError",
+			partial:          true,
+			weatherStatus:    http.StatusInternalServerError,
+			wantStatus:       http.StatusOK,
+			wantWeatherError: "weather provider unavailable",
+		},
+		{
+			name:             "quota spent",
+			partial:          true,
+			weatherStatus:    http.StatusForbidden,
+			weatherBody:      `{"error":{"code":2008,"message":"API key has been disabled."}}`,
+			wantStatus:       http.StatusOK,
+			wantWeatherError: "weather provider quota exceeded",
+		},
+		{
+			name:          "off by default",
+			weatherStatus: http.StatusInternalServerError,
+			wantStatus:    http.StatusBadGateway,
+		},
+		{
+			name:          "unknown location is still a 404",
+			partial:       true,
+			weatherStatus: http.StatusBadRequest,
+			weatherBody:   `{"error":{"code":1006,"message":"No matching location found."}}`,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "stale weather is preferred",
+			partial:       true,
+			stale:         true,
+			weatherStatus: http.StatusInternalServerError,
+			wantStatus:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeTime(t)
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			var failing atomic.Bool
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !failing.Load() {
+					w.Write([]byte(`{"current":{"temp_c":21.5}}`))
+					return
+				}
+				w.WriteHeader(tt.weatherStatus)
+				w.Write([]byte(tt.weatherBody))
+			}))
+			prevPartial, prevServe, prevStore := partialOnWeatherFailure, serveStale, lastGood
+			partialOnWeatherFailure, serveStale, lastGood = tt.partial, tt.stale, newLastGoodStore(10, time.Hour)
+			t.Cleanup(func() { partialOnWeatherFailure, serveStale, lastGood = prevPartial, prevServe, prevStore })
+
+			if tt.stale {
+				if rec := getWeather(t, "/weather?cep=01001000"); rec.Code != http.StatusOK {
+					t.Fatalf("priming request: status = %d, want 200", rec.Code)
+				}
+			}
+			failing.Store(true)
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["city"] != "São Paulo" || got["state"] != "SP" {
+				t.Errorf("body = %s, want the location", rec.Body)
+			}
+			if tt.stale {
+				if got["temp_C"] != 21.5 || got["weatherError"] != nil {
+					t.Errorf("body = %s, want the stale weather without weatherError", rec.Body)
+				}
+				return
+			}
+			if got["weatherError"] != tt.wantWeatherError {
+				t.Errorf("weatherError = %v, want %q", got["weatherError"], tt.wantWeatherError)
+			}
+			if _, ok := got["temp_C"]; ok {
+				t.Errorf("body = %s, want no temperatures", rec.Body)
+			}
+			if cc := rec.Header().Get("Cache-Control"); cc != "no-cache" {
+				t.Errorf("Cache-Control = %q, want no-cache", cc)
+			}
+		})
+	}
+}
+
+func TestParseLang(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", defaultWeatherLang, false},
+		{"en", "en", false},
+		{"zh_tw", "zh_tw", false},
+		{"xx", "", true},
+		{"abc_defg", "", true},
+		{"EN", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseLang(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLang(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
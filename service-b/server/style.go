@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// camelOutput makes /weather JSON use camelCase keys (tempC rather than
+// temp_C); set from OUTPUT_STYLE=camel. XML is unaffected.
+var camelOutput bool
+
+// outputStyleHeader lets a client pick the key style of its own response,
+// "snake" or "camel", whatever OUTPUT_STYLE says. service-a always asks for
+// snake, which its decoders expect.
+const outputStyleHeader = "X-Output-Style"
+
+// wantsCamel reports whether the response to r should use camelCase keys.
+func wantsCamel(r *http.Request) bool {
+	switch r.Header.Get(outputStyleHeader) {
+	case "snake":
+		return false
+	case "camel":
+		return true
+	}
+	return camelOutput
+}
+
+// camelizeJSON rewrites the object keys of the JSON document b to camelCase,
+// keeping their order and every value as is.
+func camelizeJSON(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	// containers tracks the open objects and arrays; n counts the tokens
+	// written in each, so in an object odd positions are keys.
+	type container struct {
+		object bool
+		n      int
+	}
+	var (
+		out        bytes.Buffer
+		containers []container
+	)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			if len(containers) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		isKey := false
+		if len(containers) > 0 && tok != json.Delim('}') && tok != json.Delim(']') {
+			c := &containers[len(containers)-1]
+			switch {
+			case c.object && c.n%2 == 1:
+				out.WriteByte(':')
+			case c.n > 0:
+				out.WriteByte(',')
+			}
+			isKey = c.object && c.n%2 == 0
+			c.n++
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(t))
+			if t == '{' || t == '[' {
+				containers = append(containers, container{object: t == '{'})
+			} else {
+				containers = containers[:len(containers)-1]
+			}
+		case string:
+			if isKey {
+				t = camelCase(t)
+			}
+			s, _ := json.Marshal(t)
+			out.Write(s)
+		case json.Number:
+			out.WriteString(t.String())
+		case bool, nil:
+			s, _ := json.Marshal(t)
+			out.Write(s)
+		}
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// camelCase turns a snake_case name such as "wind_kph" into "windKph".
+// Names without underscores are returned unchanged.
+func camelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if p := parts[i]; p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
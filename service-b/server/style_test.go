@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCamelizeJSON(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "weather document",
+			in:   `{"temp_C":21.5,"wind_kph":9,"coordinates":{"lat":-23.5},"forecast":[{"min_C":18,"max_C":25}],"weatherError":null}`,
+			want: `{"tempC":21.5,"windKph":9,"coordinates":{"lat":-23.5},"forecast":[{"minC":18,"maxC":25}],"weatherError":null}`,
+		},
+		{
+			name: "string values keep underscores",
+			in:   `{"cep_provider":"via_cep","tags":["a_b",true,false,1e3]}`,
+			want: `{"cepProvider":"via_cep","tags":["a_b",true,false,1e3]}`,
+		},
+		{
+			name: "empty containers",
+			in:   `{"a_b":{},"c_d":[],"e_f":[[],{}]}`,
+			want: `{"aB":{},"cD":[],"eF":[[],{}]}`,
+		},
+		{
+			name: "escaped strings",
+			in:   `{"city_name":"S\u00e3o \"Paulo\""}`,
+			want: `{"cityName":"São \"Paulo\""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := camelizeJSON([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want+"\n" {
+				t.Errorf("camelizeJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := camelizeJSON([]byte(`{"temp_C":`)); err == nil {
+		t.Error("camelizeJSON accepted truncated JSON")
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"temp_C", "tempC"},
+		{"wind_kph", "windKph"},
+		{"city", "city"},
+		{"weatherError", "weatherError"},
+		{"a__b", "aB"},
+		{"trailing_", "trailing"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := camelCase(tt.in); got != tt.want {
+			t.Errorf("camelCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWantsCamel(t *testing.T) {
+	tests := []struct {
+		configured bool
+		header     string
+		want       bool
+	}{
+		{false, "", false},
+		{true, "", true},
+		{true, "snake", false},
+		{false, "camel", true},
+		{true, "kebab", true},
+	}
+	for _, tt := range tests {
+		camelOutput = tt.configured
+		r := httptest.NewRequest(http.MethodGet, "/weather", nil)
+		if tt.header != "" {
+			r.Header.Set(outputStyleHeader, tt.header)
+		}
+		if got := wantsCamel(r); got != tt.want {
+			t.Errorf("OUTPUT_STYLE camel=%v, %s=%q: wantsCamel = %v, want %v", tt.configured, outputStyleHeader, tt.header, got, tt.want)
+		}
+	}
+	camelOutput = false
+}
+
+func TestWriteWeatherOutputStyle(t *testing.T) {
+	camelOutput = true
+	t.Cleanup(func() { camelOutput = false })
+	res := weatherResult{loc: location{City: "São Paulo", UF: "SP"}, current: weatherCurrent{TempC: 21.5}}
+
+	for _, tt := range []struct{ header, wantKey string }{{"", `"tempC"`}, {"snake", `"temp_C"`}} {
+		r := httptest.NewRequest(http.MethodGet, "/weather?cep=01001000", nil)
+		if tt.header != "" {
+			r.Header.Set(outputStyleHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		writeWeather(rec, r, "01001000", res, allUnits, tempDecimals, false)
+		if !strings.Contains(rec.Body.String(), tt.wantKey) {
+			t.Errorf("%s=%q: body %s lacks %s", outputStyleHeader, tt.header, rec.Body.String(), tt.wantKey)
+		}
+		if v := rec.Header().Get("Vary"); !strings.Contains(v, outputStyleHeader) {
+			t.Errorf("Vary = %q, want it to list %s", v, outputStyleHeader)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tempUnits selects which temperature scales appear in a response.
+type tempUnits struct {
+	C, F, K bool
+}
+
+var allUnits = tempUnits{C: true, F: true, K: true}
+
+// tempPrecision is the number of decimals temperatures are rounded to, per
+// scale.
+type tempPrecision struct {
+	C int `json:"c"`
+	F int `json:"f"`
+	K int `json:"k"`
+}
+
+// parseUnits reads the optional units parameter, a comma-separated list of
+// c, f and k in any case. An empty value selects all three.
+func parseUnits(v string) (tempUnits, error) {
+	if v == "" {
+		return allUnits, nil
+	}
+	var u tempUnits
+	for _, s := range strings.Split(v, ",") {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "c":
+			u.C = true
+		case "f":
+			u.F = true
+		case "k":
+			u.K = true
+		default:
+			return tempUnits{}, fmt.Errorf("unknown unit %q", s)
+		}
+	}
+	return u, nil
+}
+
+// temps converts a Celsius temperature to the selected scales, each rounded
+// to its decimals. Scales that were not selected are nil.
+func (u tempUnits) temps(c float64, decimals tempPrecision) (tc, tf, tk *float64) {
+	if u.C {
+		tc = ptr(roundN(c, decimals.C))
+	}
+	if u.F {
+		tf = ptr(roundN(celsiusToFahrenheit(c), decimals.F))
+	}
+	if u.K {
+		tk = ptr(roundN(celsiusToKelvin(c), decimals.K))
+	}
+	return tc, tf, tk
+}
+
+// parseFormat reads the optional format parameter and returns the precision
+// to round temperatures to. "int" rounds every scale to whole degrees, which
+// encoding/json then writes as integers; empty or "float" keeps
+// tempDecimals.
+func parseFormat(v string) (tempPrecision, error) {
+	switch v {
+	case "", "float":
+		return tempDecimals, nil
+	case "int":
+		return tempPrecision{}, nil
+	}
+	return tempPrecision{}, fmt.Errorf("unknown format %q", v)
+}
+
+func ptr(v float64) *float64 { return &v }
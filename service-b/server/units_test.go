@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTempsPrecision(t *testing.T) {
+	tests := []struct {
+		decimals            tempPrecision
+		wantC, wantF, wantK float64
+	}{
+		{tempPrecision{C: 1, F: 1, K: 1}, 21.6, 70.8, 294.7},
+		{tempPrecision{C: 2, F: 2, K: 2}, 21.57, 70.82, 294.72},
+		{tempPrecision{C: 0, F: 0, K: 0}, 22, 71, 295},
+		{tempPrecision{C: 3, F: 1, K: 0}, 21.567, 70.8, 295},
+	}
+	for _, tt := range tests {
+		tc, tf, tk := allUnits.temps(21.567, tt.decimals)
+		if *tc != tt.wantC || *tf != tt.wantF || *tk != tt.wantK {
+			t.Errorf("temps(21.567, %+v) = %v, %v, %v; want %v, %v, %v",
+				tt.decimals, *tc, *tf, *tk, tt.wantC, tt.wantF, tt.wantK)
+		}
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    tempUnits
+		wantErr bool
+	}{
+		{"", allUnits, false},
+		{"c", tempUnits{C: true}, false},
+		{"F", tempUnits{F: true}, false},
+		{"c,k", tempUnits{C: true, K: true}, false},
+		{" K , f ", tempUnits{F: true, K: true}, false},
+		{"c,f,k", allUnits, false},
+		{"r", tempUnits{}, true},
+		{"c,", tempUnits{}, true},
+		{"celsius", tempUnits{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUnits(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseUnits(%q) = %+v, %v; want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandleWeatherUnits(t *testing.T) {
+	withMockMode(t)
+	tests := []struct {
+		units               string
+		wantC, wantF, wantK bool
+	}{
+		{"", true, true, true},
+		{"c", true, false, false},
+		{"f,k", false, true, true},
+		{"K", false, false, true},
+	}
+	for _, tt := range tests {
+		rec := getWeather(t, "/weather?cep=01001000&days=1&units="+tt.units)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("units=%q: status = %d, want 200: %s", tt.units, rec.Code, rec.Body)
+		}
+		var got out
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if (got.TempC != nil) != tt.wantC || (got.TempF != nil) != tt.wantF || (got.TempK != nil) != tt.wantK {
+			t.Errorf("units=%q: temp_C %v, temp_F %v, temp_K %v present; want %v, %v, %v",
+				tt.units, got.TempC != nil, got.TempF != nil, got.TempK != nil, tt.wantC, tt.wantF, tt.wantK)
+		}
+		if len(got.Forecast) != 1 {
+			t.Fatalf("units=%q: got %d forecast days, want 1", tt.units, len(got.Forecast))
+		}
+		if f := got.Forecast[0]; (f.MinC != nil) != tt.wantC || (f.MaxF != nil) != tt.wantF || (f.MinK != nil) != tt.wantK {
+			t.Errorf("units=%q: forecast %+v does not match the selected units", tt.units, f)
+		}
+	}
+
+	rec := getWeather(t, "/weather?cep=01001000&units=c,r")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("units=c,r: status = %d, want 422", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "invalid_units" {
+		t.Errorf("units=c,r: code = %q, want invalid_units", e.Error.Code)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    tempPrecision
+		wantErr bool
+	}{
+		{"", tempDecimals, false},
+		{"float", tempDecimals, false},
+		{"int", tempPrecision{}, false},
+		{"INT", tempPrecision{}, true},
+		{"integer", tempPrecision{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFormat(tt.v)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseFormat(%q) = %+v, %v; want %+v, error %v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandleWeatherIntFormat(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.6}}`))
+	}))
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"int", []string{`"temp_C":22,`, `"temp_F":71,`, `"temp_K":295,`}},
+		{"", []string{`"temp_C":21.6,`, `"temp_F":70.9,`, `"temp_K":294.8,`}},
+	}
+	for _, tt := range tests {
+		rec := getWeather(t, "/weather?cep=01001000&format="+tt.format)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("format=%q: status = %d, want 200: %s", tt.format, rec.Code, rec.Body)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("format=%q: body %s does not contain %s", tt.format, rec.Body, want)
+			}
+		}
+	}
+
+	rec := getWeather(t, "/weather?cep=01001000&format=integer")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("format=integer: status = %d, want 422", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "invalid_format" {
+		t.Errorf("format=integer: code = %q, want invalid_format", e.Error.Code)
+	}
+}
+
+func TestHandleWeatherPerScaleDecimals(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.567}}`))
+	}))
+	prev := tempDecimals
+	t.Cleanup(func() { tempDecimals = prev })
+
+	tests := []struct {
+		decimals            tempPrecision
+		format              string
+		wantC, wantF, wantK float64
+	}{
+		{tempPrecision{C: 1, F: 1, K: 1}, "", 21.6, 70.8, 294.7},
+		{tempPrecision{C: 1, F: 0, K: 2}, "", 21.6, 71, 294.72},
+		{tempPrecision{C: 0, F: 2, K: 0}, "", 22, 70.82, 295},
+		{tempPrecision{C: 3, F: 3, K: 3}, "int", 22, 71, 295},
+	}
+	for _, tt := range tests {
+		tempDecimals = tt.decimals
+		rec := getWeather(t, "/weather?cep=01001000&format="+tt.format)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%+v: status = %d, want 200: %s", tt.decimals, rec.Code, rec.Body)
+		}
+		var got struct {
+			TempC float64 `json:"temp_C"`
+			TempF float64 `json:"temp_F"`
+			TempK float64 `json:"temp_K"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.TempC != tt.wantC || got.TempF != tt.wantF || got.TempK != tt.wantK {
+			t.Errorf("decimals %+v format=%q: temps = %v, %v, %v; want %v, %v, %v",
+				tt.decimals, tt.format, got.TempC, got.TempF, got.TempK, tt.wantC, tt.wantF, tt.wantK)
+		}
+	}
+}
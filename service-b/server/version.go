@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X service-b/server.version=... -X service-b/server.commit=...
+// -X service-b/server.buildTime=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+type versionResp struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// handleVersion reports the build metadata. It is intentionally not traced.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionResp{Version: version, Commit: commit, BuildTime: buildTime})
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"service-b/platform"
+)
+
+// maxWarmCEPs bounds the CEPs a single /cache/warm request may list.
+const maxWarmCEPs = 100
+
+// warmConcurrency bounds the lookups a warm-up has in flight, so it finishes
+// in reasonable time while staying gentle on the providers.
+const warmConcurrency = 4
+
+var errInvalidZipcode = errors.New("invalid zipcode")
+
+type warmReq struct {
+	CEPs []string `json:"ceps"`
+}
+
+type warmResult struct {
+	CEP   string `json:"cep"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type warmResp struct {
+	Results []warmResult `json:"results"`
+}
+
+// warmCEP looks cep up and stores it in cepCache, so the first /weather for
+// it does not pay for the lookup. In mock mode there is nothing to cache.
+func warmCEP(ctx context.Context, cep string) error {
+	if !cepRegex.MatchString(cep) || allSameDigit(cep) {
+		return errInvalidZipcode
+	}
+	if mockMode {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+	loc, err := lookupLocation(ctx, cep)
+	if err != nil {
+		return err
+	}
+	cepCache.Set(cep, loc)
+	return nil
+}
+
+// warmCEPs warms ceps with at most warmConcurrency lookups in flight.
+// Results keep the order of ceps.
+func warmCEPs(ctx context.Context, ceps []string) []warmResult {
+	results := make([]warmResult, len(ceps))
+	sem := make(chan struct{}, warmConcurrency)
+	var wg sync.WaitGroup
+	for i, cep := range ceps {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := warmResult{CEP: cep, OK: true}
+			if err := warmCEP(ctx, cep); err != nil {
+				res.OK = false
+				res.Error = warmError(err)
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// warmError describes a failed warm-up without exposing upstream details.
+func warmError(err error) string {
+	switch {
+	case errors.Is(err, errZipcodeNotFound):
+		return "zipcode not found"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "lookup timed out"
+	case errors.Is(err, errInvalidZipcode):
+		return err.Error()
+	}
+	return "lookup failed"
+}
+
+// warmCEPsAtStartup warms the comma-separated CEPs of WARM_CEPS in the
+// background and logs how many succeeded.
+func warmCEPsAtStartup(list string) {
+	var ceps []string
+	for _, cep := range strings.Split(list, ",") {
+		if cep = strings.TrimSpace(cep); cep != "" {
+			ceps = append(ceps, cep)
+		}
+	}
+	if len(ceps) == 0 {
+		return
+	}
+	go func() {
+		ok := 0
+		for _, res := range warmCEPs(context.Background(), ceps) {
+			if res.OK {
+				ok++
+			} else {
+				slog.Warn("cep cache warm-up failed", "cep", res.CEP, "error", res.Error)
+			}
+		}
+		slog.Info("cep cache warmed", "ceps", len(ceps), "ok", ok)
+	}()
+}
+
+// handleCacheWarm warms the CEPs listed in a {"ceps": [...]} body and
+// reports the outcome of each. It is only registered when
+// ENABLE_DEBUG_ENDPOINTS is set.
+func handleCacheWarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		platform.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	var payload warmReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&payload); err != nil {
+		platform.WriteError(w, r, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	if len(payload.CEPs) > maxWarmCEPs {
+		platform.WriteError(w, r, http.StatusUnprocessableEntity, "too_many_ceps", fmt.Sprintf("at most %d ceps per request", maxWarmCEPs))
+		return
+	}
+	// Every lookup may take upstreamTimeout with retries, so a full list can
+	// outlast platform.WriteTimeout before the response is written.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.DebugContext(r.Context(), "can not lift write deadline", "error", err)
+	}
+	results := warmCEPs(r.Context(), payload.CEPs)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warmResp{Results: results})
+}
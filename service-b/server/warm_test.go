@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWarmCEPsBoundedConcurrency(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+		full     = make(chan struct{})
+		once     sync.Once
+	)
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxSeen = max(maxSeen, inFlight)
+		if inFlight == warmConcurrency {
+			once.Do(func() { close(full) })
+		}
+		mu.Unlock()
+
+		// Hold the first lookups until the pool is full.
+		select {
+		case <-full:
+		case <-time.After(time.Second):
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if strings.Contains(r.URL.Path, "12345678") {
+			fmt.Fprint(w, `{"erro":"true"}`)
+			return
+		}
+		fmt.Fprint(w, `{"localidade":"São Paulo","uf":"SP"}`)
+	}))
+
+	ceps := []string{"01001000", "01310100", "12345678", "abc", "20040002", "30130010", "40010000", "50010000"}
+	results := warmCEPs(context.Background(), ceps)
+
+	if maxSeen != warmConcurrency {
+		t.Errorf("max lookups in flight = %d, want %d", maxSeen, warmConcurrency)
+	}
+	for i, res := range results {
+		if res.CEP != ceps[i] {
+			t.Fatalf("results[%d].CEP = %q, want %q", i, res.CEP, ceps[i])
+		}
+	}
+	if results[2].OK || results[2].Error != "zipcode not found" {
+		t.Errorf("unknown CEP result = %+v", results[2])
+	}
+	if results[3].OK || results[3].Error != "invalid zipcode" {
+		t.Errorf("invalid CEP result = %+v", results[3])
+	}
+	if _, ok, _ := cepCache.Get("01001000"); !results[0].OK || !ok {
+		t.Errorf("01001000 not warmed: %+v", results[0])
+	}
+}
+
+func TestWarmError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errZipcodeNotFound, "zipcode not found"},
+		{fmt.Errorf("viacep: %w", context.DeadlineExceeded), "lookup timed out"},
+		{errInvalidZipcode, "invalid zipcode"},
+		{errors.New("viacep status 500"), "lookup failed"},
+	}
+	for _, tt := range tests {
+		if got := warmError(tt.err); got != tt.want {
+			t.Errorf("warmError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCacheWarm(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"localidade":"São Paulo","uf":"SP"}`)
+	}))
+
+	tooMany := make([]string, maxWarmCEPs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%08d", 1001000+i)
+	}
+	tooManyBody, _ := json.Marshal(warmReq{CEPs: tooMany})
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"warms the listed ceps", http.MethodPost, `{"ceps":["01001000","abc"]}`, http.StatusOK, ""},
+		{"empty list", http.MethodPost, `{"ceps":[]}`, http.StatusOK, ""},
+		{"wrong method", http.MethodGet, ``, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"not json", http.MethodPost, `01001000`, http.StatusBadRequest, "invalid_request"},
+		{"too many ceps", http.MethodPost, string(tooManyBody), http.StatusUnprocessableEntity, "too_many_ceps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleCacheWarm(rec, httptest.NewRequest(tt.method, "/cache/warm", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+					t.Errorf("code = %q, want %s", e.Error.Code, tt.wantCode)
+				}
+				return
+			}
+			var got warmResp
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var req warmReq
+			json.Unmarshal([]byte(tt.body), &req)
+			if len(got.Results) != len(req.CEPs) {
+				t.Fatalf("results = %+v, want one per cep in %v", got.Results, req.CEPs)
+			}
+			for i, res := range got.Results {
+				if wantOK := req.CEPs[i] != "abc"; res.OK != wantOK {
+					t.Errorf("result for %s = %+v, want ok %v", req.CEPs[i], res, wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmCEPsAtStartup(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"localidade":"São Paulo","uf":"SP"}`)
+	}))
+
+	warmCEPsAtStartup(" 01001000, ,01310100,")
+	for _, cep := range []string{"01001000", "01310100"} {
+		waitForCity(t, cep, "São Paulo")
+	}
+}
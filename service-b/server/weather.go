@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// weatherAPINoLocationFound is weatherapi's error code for a query it
+	// cannot resolve to a location.
+	weatherAPINoLocationFound = 1006
+
+	// weatherAPIQuotaExceeded is weatherapi's error code, sent with a 403,
+	// for an API key that has used up its monthly calls.
+	weatherAPIQuotaExceeded = 2008
+)
+
+// errLocationNotFound means the weather provider could not resolve the
+// location, as opposed to being unavailable.
+var errLocationNotFound = errors.New("weather location not found")
+
+type weatherAPIError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type weatherResp struct {
+	Current weatherCurrent `json:"current"`
+}
+
+// weatherCurrent is the provider-independent view of current conditions.
+// AirQuality is only set when it was requested and the provider has it.
+type weatherCurrent struct {
+	TempC      float64     `json:"temp_c"`
+	Humidity   int         `json:"humidity"`
+	WindKph    float64     `json:"wind_kph"`
+	AirQuality *airQuality `json:"air_quality"`
+	Condition  struct {
+		Text string `json:"text"`
+	} `json:"condition"`
+}
+
+// airQuality holds particulate concentrations in μg/m³.
+type airQuality struct {
+	PM25 float64 `json:"pm2_5"`
+	PM10 float64 `json:"pm10"`
+}
+
+// weatherOptions are the optional extras a client can ask for. Lang is the
+// language of the condition text, for providers that localize it.
+type weatherOptions struct {
+	AQI  bool
+	Lang string
+}
+
+// defaultWeatherLang is the condition text language when the client does
+// not pick one.
+const defaultWeatherLang = "pt"
+
+// weatherLangs are the condition text languages weatherapi supports.
+var weatherLangs = map[string]bool{
+	"ar": true, "bg": true, "bn": true, "cs": true, "da": true, "de": true,
+	"el": true, "en": true, "es": true, "fi": true, "fr": true, "hi": true,
+	"hu": true, "it": true, "ja": true, "jv": true, "ko": true, "mr": true,
+	"nl": true, "pa": true, "pl": true, "pt": true, "ro": true, "ru": true,
+	"si": true, "sk": true, "sr": true, "sv": true, "ta": true, "te": true,
+	"tr": true, "uk": true, "ur": true, "vi": true, "zh": true, "zh_cmn": true,
+	"zh_hsn": true, "zh_tw": true, "zh_wuu": true, "zh_yue": true, "zu": true,
+}
+
+// parseLang reads the optional lang parameter, defaulting to
+// defaultWeatherLang. Only languages in weatherLangs are accepted, which
+// also bounds the distinct lastGood keys a client can create.
+func parseLang(v string) (string, error) {
+	if v == "" {
+		return defaultWeatherLang, nil
+	}
+	if !weatherLangs[v] {
+		return "", fmt.Errorf("invalid lang %q", v)
+	}
+	return v, nil
+}
+
+type weatherForecastResp struct {
+	Current  weatherCurrent `json:"current"`
+	Forecast struct {
+		ForecastDay []struct {
+			Date string `json:"date"`
+			Day  struct {
+				MaxTempC float64 `json:"maxtemp_c"`
+				MinTempC float64 `json:"mintemp_c"`
+			} `json:"day"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+// forecastDay is the provider-independent view of one forecast day.
+type forecastDay struct {
+	Date string
+	MinC float64
+	MaxC float64
+}
+
+type openWeatherMapResp struct {
+	Main struct {
+		Temp     float64 `json:"temp"`
+		Humidity int     `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"` // m/s with units=metric
+	} `json:"wind"`
+}
+
+// weatherFetcher returns the current conditions for loc, preferring its
+// coordinates over the city name when they are known.
+type weatherFetcher func(ctx context.Context, loc location, opts weatherOptions) (weatherCurrent, error)
+
+// forecastFetcher is like weatherFetcher but also returns a forecast for the
+// given number of days.
+type forecastFetcher func(ctx context.Context, loc location, days int, opts weatherOptions) (weatherCurrent, []forecastDay, error)
+
+// weatherProvider describes a weather backend. forecast is nil for providers
+// without forecast support; airQuality reports whether it honors opts.AQI.
+type weatherProvider struct {
+	fetch      weatherFetcher
+	forecast   forecastFetcher
+	airQuality bool
+	baseURL    string
+}
+
+// weatherProviders maps WEATHER_PROVIDER values to their fetchers and default
+// base URLs.
+var weatherProviders = map[string]weatherProvider{
+	"weatherapi":     {fetchWeatherAPI, fetchWeatherAPIForecast, true, "https://api.weatherapi.com/v1"},
+	"openweathermap": {fetchOpenWeatherMap, nil, false, "https://api.openweathermap.org/data/2.5"},
+}
+
+// weatherBaseURL is the selected provider's base URL, overridable with
+// WEATHER_BASE_URL.
+var weatherBaseURL = "https://api.weatherapi.com/v1"
+
+func fetchWeatherAPI(ctx context.Context, loc location, opts weatherOptions) (weatherCurrent, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "weatherapi current")
+	defer span.End()
+	defer metrics.ObserveUpstream("weatherapi", time.Now())
+	span.SetAttributes(weatherQueryAttr(loc))
+
+	var wresp weatherResp
+	if err := getWeatherAPI(ctx, "current.json", weatherAPIParams(loc, opts), &wresp); err != nil {
+		return weatherCurrent{}, err
+	}
+	return wresp.Current, nil
+}
+
+// fetchWeatherAPIForecast returns the current conditions plus a forecast for
+// the next days days, from a single forecast.json call.
+func fetchWeatherAPIForecast(ctx context.Context, loc location, days int, opts weatherOptions) (weatherCurrent, []forecastDay, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "weatherapi forecast")
+	defer span.End()
+	defer metrics.ObserveUpstream("weatherapi", time.Now())
+	span.SetAttributes(weatherQueryAttr(loc), attribute.Int("weather.days", days))
+
+	params := weatherAPIParams(loc, opts)
+	params.Set("days", strconv.Itoa(days))
+
+	var fresp weatherForecastResp
+	if err := getWeatherAPI(ctx, "forecast.json", params, &fresp); err != nil {
+		return weatherCurrent{}, nil, err
+	}
+	forecast := make([]forecastDay, 0, len(fresp.Forecast.ForecastDay))
+	for _, d := range fresp.Forecast.ForecastDay {
+		forecast = append(forecast, forecastDay{Date: d.Date, MinC: d.Day.MinTempC, MaxC: d.Day.MaxTempC})
+	}
+	return fresp.Current, forecast, nil
+}
+
+// weatherAPIParams builds the common weatherapi query, using coordinates
+// when loc has them and the city name otherwise.
+func weatherAPIParams(loc location, opts weatherOptions) url.Values {
+	q := loc.City
+	if loc.hasCoordinates() {
+		q = fmt.Sprintf("%f,%f", *loc.Lat, *loc.Lon)
+	}
+	aqi := "no"
+	if opts.AQI {
+		aqi = "yes"
+	}
+	params := url.Values{"key": {weatherAPIKey}, "q": {q}, "aqi": {aqi}}
+	if opts.Lang != "" {
+		params.Set("lang", opts.Lang)
+	}
+	return params
+}
+
+// getWeatherAPI calls a weatherapi endpoint and decodes the JSON response
+// into dst.
+func getWeatherAPI(ctx context.Context, endpoint string, params url.Values, dst any) error {
+	u := fmt.Sprintf("%s/%s?%s", weatherBaseURL, endpoint, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doWithRetry(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		var e weatherAPIError
+		if json.Unmarshal(b, &e) == nil {
+			switch {
+			case e.Error.Code == weatherAPINoLocationFound:
+				return errLocationNotFound
+			case e.Error.Code == weatherAPIQuotaExceeded && resp.StatusCode == http.StatusForbidden:
+				wait, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+				return &quotaExceededError{provider: "weatherapi", retryAfter: wait}
+			}
+		}
+		return fmt.Errorf("weather status %d: %s", resp.StatusCode, string(b))
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func fetchOpenWeatherMap(ctx context.Context, loc location, _ weatherOptions) (weatherCurrent, error) {
+	ctx, span := otel.Tracer("service-b").Start(ctx, "openweathermap current")
+	defer span.End()
+	defer metrics.ObserveUpstream("openweathermap", time.Now())
+
+	q := "q=" + url.QueryEscape(loc.City+",BR")
+	if loc.hasCoordinates() {
+		q = fmt.Sprintf("lat=%f&lon=%f", *loc.Lat, *loc.Lon)
+	}
+	span.SetAttributes(weatherQueryAttr(loc))
+
+	u := fmt.Sprintf("%s/weather?%s&appid=%s&units=metric",
+		weatherBaseURL, q, weatherAPIKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return weatherCurrent{}, err
+	}
+	resp, err := doWithRetry(req)
+	if err != nil {
+		return weatherCurrent{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return weatherCurrent{}, errLocationNotFound
+	}
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return weatherCurrent{}, fmt.Errorf("openweathermap status %d: %s", resp.StatusCode, string(b))
+	}
+	var o openWeatherMapResp
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return weatherCurrent{}, err
+	}
+	return weatherCurrent{
+		TempC:    o.Main.Temp,
+		Humidity: o.Main.Humidity,
+		WindKph:  o.Wind.Speed * 3.6,
+	}, nil
+}
+
+// weatherQueryAttr records whether the weather query used coordinates or the
+// city name.
+func weatherQueryAttr(loc location) attribute.KeyValue {
+	if loc.hasCoordinates() {
+		return attribute.String("weather.query", "coordinates")
+	}
+	return attribute.String("weather.query", "city")
+}
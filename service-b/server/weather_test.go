@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"service-b/platform"
+)
+
+// withWeatherAPI serves weatherapi calls from h for the duration of the test
+// and makes weatherapi the provider.
+func withWeatherAPI(t *testing.T, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	prevURL, prevKey, prevName := weatherBaseURL, weatherAPIKey, weatherProviderName
+	prevWeather, prevForecast, prevAQI := fetchWeather, fetchForecast, airQualitySupported
+	prevClient := httpClient
+	weatherBaseURL, weatherAPIKey, weatherProviderName = srv.URL, "test-key", "weatherapi"
+	fetchWeather, fetchForecast, airQualitySupported = fetchWeatherAPI, fetchWeatherAPIForecast, true
+	if httpClient == nil {
+		httpClient = platform.NewHTTPClient()
+	}
+	t.Cleanup(func() {
+		weatherBaseURL, weatherAPIKey, weatherProviderName = prevURL, prevKey, prevName
+		fetchWeather, fetchForecast, airQualitySupported = prevWeather, prevForecast, prevAQI
+		httpClient = prevClient
+	})
+}
+
+func TestFetchWeatherAPIHumidityAndWind(t *testing.T) {
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.5,"humidity":73,"wind_kph":14.4,"condition":{"text":"Nublado"}}}`))
+	}))
+
+	current, err := fetchWeatherAPI(context.Background(), location{City: "São Paulo"}, weatherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.TempC != 21.5 || current.Humidity != 73 || current.WindKph != 14.4 {
+		t.Errorf("current = %+v, want 21.5 °C, 73%% humidity, 14.4 km/h wind", current)
+	}
+}
+
+func TestHandleWeatherHumidityAndWind(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"current":{"temp_c":21.5,"humidity":73,"wind_kph":14.4}}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["humidity"] != 73.0 || got["wind_kph"] != 14.4 {
+		t.Errorf("humidity, wind_kph = %v, %v; want 73, 14.4", got["humidity"], got["wind_kph"])
+	}
+}
+
+func TestValidateWeatherAPIKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"abc123", "abc123", false},
+		{"  abc123\n", "abc123", false},
+		{"", "", true},
+		{"   ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := validateWeatherAPIKey(tt.key)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("validateWeatherAPIKey(%q) = %q, %v; want %q, error %v", tt.key, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetWeatherAPISendsKey(t *testing.T) {
+	var got string
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("key")
+		w.Write([]byte(`{"current":{}}`))
+	}))
+
+	if _, err := fetchWeatherAPI(context.Background(), location{City: "São Paulo"}, weatherOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "test-key" {
+		t.Errorf("key = %q, want the configured test-key", got)
+	}
+}
+
+func TestFetchOpenWeatherMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    weatherCurrent
+		wantErr error
+	}{
+		{"found", http.StatusOK, `{"main":{"temp":18.2,"humidity":80},"wind":{"speed":5}}`,
+			weatherCurrent{TempC: 18.2, Humidity: 80, WindKph: 18}, nil},
+		{"unknown city", http.StatusNotFound, `{"cod":"404","message":"city not found"}`,
+			weatherCurrent{}, errLocationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query url.Values
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/weather" {
+					t.Errorf("path = %q, want /weather", r.URL.Path)
+				}
+				query = r.URL.Query()
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			got, err := fetchOpenWeatherMap(context.Background(), location{City: "Curitiba"}, weatherOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("current = %+v, want %+v", got, tt.want)
+			}
+			if query.Get("q") != "Curitiba,BR" || query.Get("appid") != "test-key" || query.Get("units") != "metric" {
+				t.Errorf("query = %v, want q=Curitiba,BR, appid=test-key and units=metric", query)
+			}
+		})
+	}
+}
+
+func TestOpenWeatherMapHasNoForecast(t *testing.T) {
+	withMockMode(t)
+	fetchForecast = weatherProviders["openweathermap"].forecast
+
+	rec := getWeather(t, "/weather?cep=01001000&days=2")
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "forecast_unsupported" {
+		t.Errorf("code = %q, want forecast_unsupported", e.Error.Code)
+	}
+}
+
+func TestWeatherAPIParamsQuery(t *testing.T) {
+	lat, lon := -23.5505, -46.6333
+	tests := []struct {
+		name string
+		loc  location
+		want string
+	}{
+		{"city", location{City: "São Paulo"}, "São Paulo"},
+		{"coordinates", location{City: "São Paulo", Lat: &lat, Lon: &lon}, "-23.550500,-46.633300"},
+		{"latitude only", location{City: "São Paulo", Lat: &lat}, "São Paulo"},
+	}
+	for _, tt := range tests {
+		if got := weatherAPIParams(tt.loc, weatherOptions{}).Get("q"); got != tt.want {
+			t.Errorf("%s: q = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFetchOpenWeatherMapCoordinates(t *testing.T) {
+	lat, lon := -25.43, -49.27
+	var query url.Values
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"main":{"temp":18}}`))
+	}))
+
+	if _, err := fetchOpenWeatherMap(context.Background(), location{City: "Curitiba", Lat: &lat, Lon: &lon}, weatherOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if query.Has("q") || query.Get("lat") != "-25.430000" || query.Get("lon") != "-49.270000" {
+		t.Errorf("query = %v, want lat and lon without q", query)
+	}
+}
+
+func TestHandleWeatherForecast(t *testing.T) {
+	var query url.Values
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/forecast.json" {
+			t.Errorf("path = %q, want /forecast.json", r.URL.Path)
+		}
+		query = r.URL.Query()
+		w.Write([]byte(`{"current":{"temp_c":20},"forecast":{"forecastday":[` +
+			`{"date":"2024-01-01","day":{"mintemp_c":15,"maxtemp_c":25}},` +
+			`{"date":"2024-01-02","day":{"mintemp_c":-5,"maxtemp_c":0}}]}}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000&days=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if query.Get("days") != "2" {
+		t.Errorf("days = %q, want 2", query.Get("days"))
+	}
+	var got out
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Forecast) != 2 {
+		t.Fatalf("got %d forecast days, want 2", len(got.Forecast))
+	}
+	day := got.Forecast[1]
+	if day.Date != "2024-01-02" || *day.MinC != -5 || *day.MaxF != 32 || *day.MinK != 268.2 {
+		t.Errorf("second day = %s min %v °C, max %v °F, min %v K; want 2024-01-02, -5, 32, 268.2",
+			day.Date, *day.MinC, *day.MaxF, *day.MinK)
+	}
+}
+
+func TestHandleWeatherWithoutDaysHasNoForecast(t *testing.T) {
+	withMockMode(t)
+	rec := getWeather(t, "/weather?cep=01001000")
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["forecast"]; ok {
+		t.Errorf("body = %s, want no forecast", rec.Body)
+	}
+}
+
+func TestFetchWeatherAPINoLocationFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+	}{
+		{"no matching location", http.StatusBadRequest, `{"error":{"code":1006,"message":"No matching location found."}}`, errLocationNotFound},
+		{"other api error", http.StatusBadRequest, `{"error":{"code":1003,"message":"Parameter q is missing."}}`, nil},
+		{"not json", http.StatusBadRequest, `bad request`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			_, err := fetchWeatherAPI(context.Background(), location{City: "Nowhere"}, weatherOptions{})
+			if err == nil {
+				t.Fatal("fetchWeatherAPI succeeded, want an error")
+			}
+			if got := errors.Is(err, errLocationNotFound); got != (tt.wantErr != nil) {
+				t.Errorf("err = %v, is errLocationNotFound %v; want %v", err, got, tt.wantErr != nil)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherLocationNotFound(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"Nowhere"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":1006,"message":"No matching location found."}}`))
+	}))
+
+	rec := getWeather(t, "/weather?cep=01001000")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "location_not_found" {
+		t.Errorf("code = %q, want location_not_found", e.Error.Code)
+	}
+}
+
+func TestHandleWeatherQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		retryAfter     string
+		body           string
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+	}{
+		{
+			name:           "quota spent with reset time",
+			status:         http.StatusForbidden,
+			retryAfter:     "3600",
+			body:           `{"error":{"code":2008,"message":"API key has been disabled."}}`,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantCode:       "upstream_quota_exceeded",
+			wantRetryAfter: "3600",
+		},
+		{
+			name:       "quota spent without reset time",
+			status:     http.StatusForbidden,
+			body:       `{"error":{"code":2008,"message":"API key has been disabled."}}`,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "upstream_quota_exceeded",
+		},
+		{
+			name:       "2008 without a 403",
+			status:     http.StatusBadRequest,
+			body:       `{"error":{"code":2008,"message":"API key has been disabled."}}`,
+			wantStatus: http.StatusBadGateway,
+			wantCode:   "bad_gateway",
+		},
+		{
+			name:       "other 403",
+			status:     http.StatusForbidden,
+			body:       `{"error":{"code":2007,"message":"API key has exceeded calls per month quota."}}`,
+			wantStatus: http.StatusBadGateway,
+			wantCode:   "bad_gateway",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+			}))
+			withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			rec := getWeather(t, "/weather?cep=01001000")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if e := decodeError(t, rec); e.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %s", e.Error.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestHandleWeatherAirQuality(t *testing.T) {
+	var aqi string
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aqi = r.URL.Query().Get("aqi")
+		if aqi == "yes" {
+			w.Write([]byte(`{"current":{"temp_c":20,"air_quality":{"pm2_5":12.3,"pm10":30.1}}}`))
+			return
+		}
+		w.Write([]byte(`{"current":{"temp_c":20}}`))
+	}))
+
+	tests := []struct {
+		target     string
+		wantAQI    string
+		wantStatus int
+		wantPM25   float64
+	}{
+		{"/weather?cep=01001000", "no", http.StatusOK, 0},
+		{"/weather?cep=01001000&aqi=false", "no", http.StatusOK, 0},
+		{"/weather?cep=01001000&aqi=true", "yes", http.StatusOK, 12.3},
+		{"/weather?cep=01001000&aqi=maybe", "", http.StatusUnprocessableEntity, 0},
+	}
+	for _, tt := range tests {
+		aqi = ""
+		rec := getWeather(t, tt.target)
+		if rec.Code != tt.wantStatus || aqi != tt.wantAQI {
+			t.Errorf("GET %s: status %d, aqi %q; want %d, %q", tt.target, rec.Code, aqi, tt.wantStatus, tt.wantAQI)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var got out
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if tt.wantPM25 == 0 && got.AirQuality != nil || tt.wantPM25 != 0 && (got.AirQuality == nil || got.AirQuality.PM25 != tt.wantPM25) {
+			t.Errorf("GET %s: air_quality = %+v, want pm2_5 %v", tt.target, got.AirQuality, tt.wantPM25)
+		}
+	}
+}
+
+func TestHandleWeatherAirQualityUnsupported(t *testing.T) {
+	withMockMode(t)
+	prev := airQualitySupported
+	airQualitySupported = false
+	t.Cleanup(func() { airQualitySupported = prev })
+
+	rec := getWeather(t, "/weather?cep=01001000&aqi=true")
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Error.Code != "aqi_unsupported" {
+		t.Errorf("code = %q, want aqi_unsupported", e.Error.Code)
+	}
+}
+
+func TestHandleWeatherLang(t *testing.T) {
+	withViaCEP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"localidade":"São Paulo","uf":"SP"}`))
+	}))
+	conditions := map[string]string{"pt": "Parcialmente nublado", "en": "Partly cloudy", "es": "Parcialmente nublado"}
+	var gotLang atomic.Value
+	withWeatherAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := r.URL.Query().Get("lang")
+		gotLang.Store(lang)
+		fmt.Fprintf(w, `{"current":{"temp_c":21.5,"condition":{"text":%q}}}`, conditions[lang])
+	}))
+
+	tests := []struct {
+		query         string
+		wantStatus    int
+		wantLang      string
+		wantCondition string
+	}{
+		{"", http.StatusOK, "pt", "Parcialmente nublado"},
+		{"&lang=en", http.StatusOK, "en", "Partly cloudy"},
+		{"&lang=es", http.StatusOK, "es", "Parcialmente nublado"},
+		{"&lang=xx", http.StatusUnprocessableEntity, "", ""},
+		{"&lang=EN", http.StatusUnprocessableEntity, "", ""},
+	}
+	for _, tt := range tests {
+		gotLang.Store("")
+		rec := getWeather(t, "/weather?cep=01001000"+tt.query)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.query, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if tt.wantStatus != http.StatusOK {
+			if e := decodeError(t, rec); e.Error.Code != "invalid_lang" {
+				t.Errorf("%s: code = %q, want invalid_lang", tt.query, e.Error.Code)
+			}
+			if l := gotLang.Load(); l != "" {
+				t.Errorf("%s: weatherapi was called with lang %q, want no call", tt.query, l)
+			}
+			continue
+		}
+		if l := gotLang.Load(); l != tt.wantLang {
+			t.Errorf("%s: weatherapi lang = %q, want %q", tt.query, l, tt.wantLang)
+		}
+		var got struct {
+			Condition string `json:"condition"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Condition != tt.wantCondition {
+			t.Errorf("%s: condition = %q, want %q", tt.query, got.Condition, tt.wantCondition)
+		}
+	}
+}